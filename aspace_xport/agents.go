@@ -0,0 +1,76 @@
+package aspace_xport
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path/filepath"
+)
+
+func isAgentType(recordType string) bool {
+	_, ok := agentTypePaths[recordType]
+	return ok
+}
+
+// export an agent as eac-cpf
+func exportAgent(ctx context.Context, info ResourceInfo, workerID int) ExportResult {
+	typePath := agentTypePaths[info.Type]
+	agentURI := fmt.Sprintf("/agents/%s/%d", typePath, info.ResourceID)
+
+	//get the agent to check that it is published
+	agent := struct {
+		Publish bool `json:"publish"`
+	}{}
+	attempts, err := withRetry(ctx, agentURI, func() error {
+		return getEndpointJSON(agentURI, &agent)
+	})
+	if err != nil {
+		PrintAndLog(fmt.Sprintf("[worker %d] could not retrieve %s, code: %s, attempts: %d", workerID, agentURI, err.Error(), attempts), ERROR)
+		return ExportResult{Status: "ERROR", URI: agentURI, Error: err.Error(), Attempts: attempts}
+	}
+
+	if exportOptions.UnpublishedResources == false && agent.Publish != true {
+		LogOnly(fmt.Sprintf("[worker %d]  agent %s not set to publish, skipping", workerID, agentURI), INFO)
+		return ExportResult{Status: "SKIPPED", URI: agentURI, Error: "", Attempts: attempts}
+	}
+
+	//get the eac-cpf
+	var eacBytes []byte
+	eacAttempts, err := withRetry(ctx, fmt.Sprintf("%s as eac-cpf", agentURI), func() error {
+		response, err := client.GetEndpoint(fmt.Sprintf("/repositories/%d/archival_contexts/%s/%d.xml", info.RepoID, typePath, info.ResourceID))
+		if err != nil {
+			return err
+		}
+		defer response.Body.Close()
+
+		eacBytes, err = io.ReadAll(response.Body)
+		return err
+	})
+	attempts = max(attempts, eacAttempts)
+	if err != nil {
+		PrintAndLog(fmt.Sprintf("[worker %d] could not retrieve %s as eac-cpf, code: %s, attempts: %d", workerID, agentURI, err.Error(), eacAttempts), ERROR)
+		return ExportResult{Status: "ERROR", URI: agentURI, Error: err.Error(), Attempts: attempts}
+	}
+
+	eacFilename := fmt.Sprintf("%s_%d.xml", typePath, info.ResourceID)
+	eacPath := filepath.Join(exportOptions.WorkDir, AgentsRepoSlug, typePath, eacFilename)
+
+	//reformat the eac-cpf, the eac-cpf is written as exported if it can not be reformatted
+	if exportOptions.Reformat == true {
+		reformattedBytes, err := ReformatXML(eacBytes, exportOptions.Indent)
+		if err != nil {
+			LogOnly(fmt.Sprintf("[worker %d] could not reformat %s: %s", workerID, eacPath, err.Error()), WARNING)
+		} else {
+			eacBytes = reformattedBytes
+		}
+	}
+
+	//write the eac-cpf file
+	if err := WriteFileAtomic(eacPath, eacBytes, 0777); err != nil {
+		LogOnly(fmt.Sprintf("[worker %d] could not write the eac-cpf file %s", workerID, agentURI), ERROR)
+		return ExportResult{Status: "ERROR", URI: agentURI, Error: err.Error(), Attempts: attempts}
+	}
+
+	LogOnly(fmt.Sprintf("[worker %d] exported agent %s - %s", workerID, agentURI, eacFilename), INFO)
+	return ExportResult{Status: "SUCCESS", URI: agentURI, Attempts: attempts, Path: eacPath, Size: int64(len(eacBytes))}
+}
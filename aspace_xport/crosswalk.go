@@ -0,0 +1,406 @@
+package aspace_xport
+
+import (
+	"encoding/xml"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/nyudlts/go-aspace"
+)
+
+// ArchivesSpace does not export resources as mods or dublin core, so they are crosswalked from the resource record
+// with its agents, subjects and repository resolved
+const (
+	modsNamespace  = "http://www.loc.gov/mods/v3"
+	modsSchema     = "http://www.loc.gov/standards/mods/v3/mods-3-7.xsd"
+	oaiDCNamespace = "http://www.openarchives.org/OAI/2.0/oai_dc/"
+	oaiDCSchema    = "http://www.openarchives.org/OAI/2.0/oai_dc.xsd"
+	dcNamespace    = "http://purl.org/dc/elements/1.1/"
+	xsiNamespace   = "http://www.w3.org/2001/XMLSchema-instance"
+)
+
+var markupPattern = regexp.MustCompile(`<[^>]*>`)
+
+// a resource record with the fields used by the crosswalks resolved
+type resourceDescription struct {
+	aspace.Resource
+	LinkedAgents []describedAgent   `json:"linked_agents"`
+	Subjects     []describedSubject `json:"subjects"`
+	Repository   struct {
+		Resolved struct {
+			Name string `json:"name"`
+		} `json:"_resolved"`
+	} `json:"repository"`
+}
+
+type describedAgent struct {
+	Role     string `json:"role"`
+	Relator  string `json:"relator"`
+	Resolved struct {
+		Title         string `json:"title"`
+		JSONModelType string `json:"jsonmodel_type"`
+	} `json:"_resolved"`
+}
+
+type describedSubject struct {
+	Resolved struct {
+		Title string `json:"title"`
+		Terms []struct {
+			TermType string `json:"term_type"`
+		} `json:"terms"`
+	} `json:"_resolved"`
+}
+
+// get the identifier of a resource, the id parts joined with periods
+func (d resourceDescription) identifier() string {
+	ids := []string{}
+	for _, id := range []string{d.ID0, d.ID1, d.ID2, d.ID3} {
+		if id != "" {
+			ids = append(ids, id)
+		}
+	}
+	return strings.Join(ids, ".")
+}
+
+// get the text of the notes of a type, unpublished notes are only included if set in the export options
+func (d resourceDescription) notes(noteTypes ...string) []string {
+	texts := []string{}
+	for _, note := range d.Notes {
+		if note.Publish != true && exportOptions.UnpublishedNotes != true {
+			continue
+		}
+		if !containsString(noteTypes, note.Type) {
+			continue
+		}
+
+		content := note.Content
+		for _, subnote := range note.Subnotes {
+			if sn, ok := subnote.(map[string]interface{}); ok {
+				if c, ok := sn["content"].(string); ok {
+					content = append(content, c)
+				}
+			}
+		}
+
+		for _, c := range content {
+			text := strings.Join(strings.Fields(markupPattern.ReplaceAllString(c, " ")), " ")
+			if text != "" {
+				texts = append(texts, text)
+			}
+		}
+	}
+	return texts
+}
+
+// get the display string of each date, falling back to the begin and end dates
+func (d resourceDescription) dates() []string {
+	dates := []string{}
+	for _, date := range d.Dates {
+		switch {
+		case date.Expression != "":
+			dates = append(dates, date.Expression)
+		case date.Begin != "" && date.End != "" && date.Begin != date.End:
+			dates = append(dates, date.Begin+"/"+date.End)
+		case date.Begin != "":
+			dates = append(dates, date.Begin)
+		}
+	}
+	return dates
+}
+
+func (d resourceDescription) extents() []string {
+	extents := []string{}
+	for _, extent := range d.Extents {
+		e := strings.TrimSpace(fmt.Sprintf("%s %s", extent.Number, strings.ReplaceAll(extent.ExtentType, "_", " ")))
+		if extent.ContainerSummary != "" {
+			e = fmt.Sprintf("%s (%s)", e, extent.ContainerSummary)
+		}
+		if e != "" {
+			extents = append(extents, e)
+		}
+	}
+	return extents
+}
+
+func (d resourceDescription) languages() []string {
+	languages := []string{}
+	for _, lang := range d.LangMaterials {
+		if lang.LanguageAndScript.Language != "" {
+			languages = append(languages, lang.LanguageAndScript.Language)
+		}
+	}
+	return languages
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+type modsRecord struct {
+	XMLName              xml.Name         `xml:"mods"`
+	Xmlns                string           `xml:"xmlns,attr"`
+	XmlnsXsi             string           `xml:"xmlns:xsi,attr"`
+	SchemaLocation       string           `xml:"xsi:schemaLocation,attr"`
+	Version              string           `xml:"version,attr"`
+	Title                string           `xml:"titleInfo>title"`
+	Names                []modsName       `xml:"name"`
+	TypeOfResource       modsType         `xml:"typeOfResource"`
+	DatesCreated         []string         `xml:"originInfo>dateCreated,omitempty"`
+	Languages            []modsLanguage   `xml:"language,omitempty"`
+	Extents              []string         `xml:"physicalDescription>extent,omitempty"`
+	Abstracts            []string         `xml:"abstract,omitempty"`
+	Notes                []modsNote       `xml:"note,omitempty"`
+	AccessConditions     []modsNote       `xml:"accessCondition,omitempty"`
+	Subjects             []modsSubject    `xml:"subject,omitempty"`
+	Identifiers          []modsIdentifier `xml:"identifier,omitempty"`
+	PhysicalLocation     string           `xml:"location>physicalLocation,omitempty"`
+	RecordIdentifier     string           `xml:"recordInfo>recordIdentifier,omitempty"`
+	RecordContentSource  string           `xml:"recordInfo>recordContentSource,omitempty"`
+	RecordOrigin         string           `xml:"recordInfo>recordOrigin"`
+	LanguageOfCataloging *modsLanguage    `xml:"recordInfo>languageOfCataloging,omitempty"`
+}
+
+type modsName struct {
+	Type     string        `xml:"type,attr,omitempty"`
+	NamePart string        `xml:"namePart"`
+	RoleTerm *modsRoleTerm `xml:"role>roleTerm,omitempty"`
+}
+
+type modsRoleTerm struct {
+	Type      string `xml:"type,attr"`
+	Authority string `xml:"authority,attr,omitempty"`
+	Value     string `xml:",chardata"`
+}
+
+type modsType struct {
+	Collection string `xml:"collection,attr,omitempty"`
+	Value      string `xml:",chardata"`
+}
+
+type modsLanguage struct {
+	Term struct {
+		Type      string `xml:"type,attr"`
+		Authority string `xml:"authority,attr"`
+		Value     string `xml:",chardata"`
+	} `xml:"languageTerm"`
+}
+
+type modsNote struct {
+	Type  string `xml:"type,attr,omitempty"`
+	Value string `xml:",chardata"`
+}
+
+type modsSubject struct {
+	Topic      string    `xml:"topic,omitempty"`
+	Geographic string    `xml:"geographic,omitempty"`
+	Temporal   string    `xml:"temporal,omitempty"`
+	Genre      string    `xml:"genre,omitempty"`
+	Name       *modsName `xml:"name,omitempty"`
+}
+
+type modsIdentifier struct {
+	Type  string `xml:"type,attr"`
+	Value string `xml:",chardata"`
+}
+
+// the mods name type of an agent record type
+func modsNameType(jsonModelType string) string {
+	switch jsonModelType {
+	case "agent_person":
+		return "personal"
+	case "agent_corporate_entity":
+		return "corporate"
+	case "agent_family":
+		return "family"
+	default:
+		return ""
+	}
+}
+
+func newModsLanguage(code string) modsLanguage {
+	language := modsLanguage{}
+	language.Term.Type = "code"
+	language.Term.Authority = "iso639-2b"
+	language.Term.Value = code
+	return language
+}
+
+// crosswalk a resource to a mods record
+func (d resourceDescription) toMODS() ([]byte, error) {
+	mods := modsRecord{
+		Xmlns:          modsNamespace,
+		XmlnsXsi:       xsiNamespace,
+		SchemaLocation: fmt.Sprintf("%s %s", modsNamespace, modsSchema),
+		Version:        "3.7",
+		Title:          d.Title,
+		TypeOfResource: modsType{Value: "mixed material"},
+		DatesCreated:   d.dates(),
+		Extents:        d.extents(),
+		Abstracts:      d.notes("abstract"),
+		RecordOrigin:   "Derived from the ArchivesSpace resource record " + d.URI,
+	}
+
+	if d.Level == "collection" || d.Level == "recordgrp" || d.Level == "fonds" {
+		mods.TypeOfResource.Collection = "yes"
+	}
+
+	for _, agent := range d.LinkedAgents {
+		name := modsName{Type: modsNameType(agent.Resolved.JSONModelType), NamePart: agent.Resolved.Title}
+		if name.NamePart == "" {
+			continue
+		}
+		if agent.Role == "subject" {
+			mods.Subjects = append(mods.Subjects, modsSubject{Name: &name})
+			continue
+		}
+		name.RoleTerm = &modsRoleTerm{Type: "text", Value: agent.Role}
+		if agent.Relator != "" {
+			name.RoleTerm.Type = "code"
+			name.RoleTerm.Authority = "marcrelator"
+			name.RoleTerm.Value = agent.Relator
+		}
+		mods.Names = append(mods.Names, name)
+	}
+
+	for _, subject := range d.Subjects {
+		if subject.Resolved.Title == "" {
+			continue
+		}
+		termType := ""
+		if len(subject.Resolved.Terms) > 0 {
+			termType = subject.Resolved.Terms[0].TermType
+		}
+		switch termType {
+		case "geographic":
+			mods.Subjects = append(mods.Subjects, modsSubject{Geographic: subject.Resolved.Title})
+		case "temporal":
+			mods.Subjects = append(mods.Subjects, modsSubject{Temporal: subject.Resolved.Title})
+		case "genre_form":
+			mods.Subjects = append(mods.Subjects, modsSubject{Genre: subject.Resolved.Title})
+		default:
+			mods.Subjects = append(mods.Subjects, modsSubject{Topic: subject.Resolved.Title})
+		}
+	}
+
+	for _, language := range d.languages() {
+		mods.Languages = append(mods.Languages, newModsLanguage(language))
+	}
+
+	for _, noteType := range []string{"scopecontent", "bioghist", "arrangement", "acqinfo", "custodhist", "prefercite", "odd"} {
+		for _, note := range d.notes(noteType) {
+			mods.Notes = append(mods.Notes, modsNote{Type: noteType, Value: note})
+		}
+	}
+
+	for _, noteType := range []string{"accessrestrict", "userestrict"} {
+		conditionType := "restriction on access"
+		if noteType == "userestrict" {
+			conditionType = "use and reproduction"
+		}
+		for _, note := range d.notes(noteType) {
+			mods.AccessConditions = append(mods.AccessConditions, modsNote{Type: conditionType, Value: note})
+		}
+	}
+
+	if identifier := d.identifier(); identifier != "" {
+		mods.Identifiers = append(mods.Identifiers, modsIdentifier{Type: "local", Value: identifier})
+	}
+	if d.ExternalArkURL != "" {
+		mods.Identifiers = append(mods.Identifiers, modsIdentifier{Type: "ark", Value: d.ExternalArkURL})
+	}
+
+	mods.PhysicalLocation = d.Repository.Resolved.Name
+	mods.RecordIdentifier = d.EADID
+	mods.RecordContentSource = d.Repository.Resolved.Name
+	if d.FindingAidLanguage != "" {
+		language := newModsLanguage(d.FindingAidLanguage)
+		mods.LanguageOfCataloging = &language
+	}
+
+	return marshalCrosswalk(mods)
+}
+
+type dcRecord struct {
+	XMLName        xml.Name `xml:"oai_dc:dc"`
+	XmlnsOAIDC     string   `xml:"xmlns:oai_dc,attr"`
+	XmlnsDC        string   `xml:"xmlns:dc,attr"`
+	XmlnsXsi       string   `xml:"xmlns:xsi,attr"`
+	SchemaLocation string   `xml:"xsi:schemaLocation,attr"`
+	Title          []string `xml:"dc:title"`
+	Creator        []string `xml:"dc:creator"`
+	Contributor    []string `xml:"dc:contributor"`
+	Subject        []string `xml:"dc:subject"`
+	Description    []string `xml:"dc:description"`
+	Publisher      []string `xml:"dc:publisher"`
+	Date           []string `xml:"dc:date"`
+	Type           []string `xml:"dc:type"`
+	Format         []string `xml:"dc:format"`
+	Identifier     []string `xml:"dc:identifier"`
+	Language       []string `xml:"dc:language"`
+	Rights         []string `xml:"dc:rights"`
+}
+
+// crosswalk a resource to an oai_dc record
+func (d resourceDescription) toDC() ([]byte, error) {
+	dc := dcRecord{
+		XmlnsOAIDC:     oaiDCNamespace,
+		XmlnsDC:        dcNamespace,
+		XmlnsXsi:       xsiNamespace,
+		SchemaLocation: fmt.Sprintf("%s %s", oaiDCNamespace, oaiDCSchema),
+		Title:          []string{d.Title},
+		Date:           d.dates(),
+		Type:           []string{"Collection"},
+		Format:         d.extents(),
+		Language:       d.languages(),
+		Rights:         d.notes("accessrestrict", "userestrict"),
+	}
+
+	for _, agent := range d.LinkedAgents {
+		if agent.Resolved.Title == "" {
+			continue
+		}
+		switch agent.Role {
+		case "creator":
+			dc.Creator = append(dc.Creator, agent.Resolved.Title)
+		case "subject":
+			dc.Subject = append(dc.Subject, agent.Resolved.Title)
+		default:
+			dc.Contributor = append(dc.Contributor, agent.Resolved.Title)
+		}
+	}
+
+	for _, subject := range d.Subjects {
+		if subject.Resolved.Title != "" {
+			dc.Subject = append(dc.Subject, subject.Resolved.Title)
+		}
+	}
+
+	dc.Description = append(dc.Description, d.notes("abstract")...)
+	dc.Description = append(dc.Description, d.notes("scopecontent")...)
+
+	if d.Repository.Resolved.Name != "" {
+		dc.Publisher = append(dc.Publisher, d.Repository.Resolved.Name)
+	}
+
+	for _, identifier := range []string{d.identifier(), d.EADID, d.ExternalArkURL} {
+		if identifier != "" {
+			dc.Identifier = append(dc.Identifier, identifier)
+		}
+	}
+
+	return marshalCrosswalk(dc)
+}
+
+func marshalCrosswalk(record interface{}) ([]byte, error) {
+	recordBytes, err := xml.MarshalIndent(record, "", exportOptions.Indent)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), append(recordBytes, '\n')...), nil
+}
@@ -0,0 +1,110 @@
+package aspace_xport
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/nyudlts/go-aspace"
+)
+
+var unsafeFilenameCharacters = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// export a digital object as mets with the descriptive metadata set in the export options
+func exportDigitalObject(ctx context.Context, info ResourceInfo, workerID int) ExportResult {
+	//get the digital object
+	var do aspace.DigitalObject
+	doURI := fmt.Sprintf("/repositories/%d/digital_objects/%d", info.RepoID, info.ResourceID)
+	attempts, err := withRetry(ctx, doURI, func() error {
+		var err error
+		do, err = client.GetDigitalObject(info.RepoID, info.ResourceID)
+		return err
+	})
+	if err != nil {
+		PrintAndLog(fmt.Sprintf("[worker %d] could not retrieve %s, code: %s, attempts: %d", workerID, doURI, err.Error(), attempts), ERROR)
+		return ExportResult{Status: "ERROR", URI: doURI, Error: err.Error(), Attempts: attempts}
+	}
+
+	//check if the digital object is set to be published
+	if exportOptions.UnpublishedResources == false && do.Publish != true {
+		LogOnly(fmt.Sprintf("[worker %d]  digital object %s not set to publish, skipping", workerID, doURI), INFO)
+		return ExportResult{Status: "SKIPPED", URI: doURI, Error: "", Attempts: attempts}
+	}
+
+	result := ExportResult{URI: doURI, Attempts: attempts}
+	formatResult := exportMETS(ctx, info, do, workerID)
+	result.Formats = []FormatResult{formatResult}
+	result.Status = formatResult.Status
+	result.Size = formatResult.Size
+	if formatResult.Attempts > result.Attempts {
+		result.Attempts = formatResult.Attempts
+	}
+	return result
+}
+
+func exportMETS(ctx context.Context, info ResourceInfo, do aspace.DigitalObject, workerID int) FormatResult {
+	result := FormatResult{Format: MODS}
+	if exportOptions.DigitalObjectDMD == "dc" {
+		result.Format = DC
+	}
+
+	//get the mets
+	var metsBytes []byte
+	doURI := fmt.Sprintf("/repositories/%d/digital_objects/%d", info.RepoID, info.ResourceID)
+	attempts, err := withRetry(ctx, fmt.Sprintf("%s as mets", doURI), func() error {
+		response, err := client.GetEndpoint(fmt.Sprintf("/repositories/%d/digital_objects/mets/%d.xml?dmd=%s", info.RepoID, info.ResourceID, exportOptions.DigitalObjectDMD))
+		if err != nil {
+			return err
+		}
+		defer response.Body.Close()
+
+		metsBytes, err = io.ReadAll(response.Body)
+		return err
+	})
+	result.Attempts = attempts
+	if err != nil {
+		PrintAndLog(fmt.Sprintf("[worker %d] could not retrieve %s as mets, code: %s, attempts: %d", workerID, doURI, err.Error(), attempts), ERROR)
+		result.Status = "ERROR"
+		result.Error = err.Error()
+		return result
+	}
+
+	//create the output filename from the digital object identifier, which can be a url, and the record ID. Replacing
+	//the characters that are not safe in a filename can map different identifiers to the same name, the ID keeps them apart
+	baseFilename := strings.Trim(unsafeFilenameCharacters.ReplaceAllString(do.DigitalObjectID, "_"), "_.")
+	if baseFilename == "" {
+		baseFilename = fmt.Sprintf("digital_object_%d", info.ResourceID)
+		LogOnly(fmt.Sprintf("[worker %d] digital object %s does not have an identifier, using its ID for filename", workerID, doURI), WARNING)
+	} else {
+		baseFilename = fmt.Sprintf("%s_%d", baseFilename, info.ResourceID)
+	}
+	metsFilename := fmt.Sprintf("%s.xml", baseFilename)
+	metsPath := filepath.Join(exportOptions.WorkDir, info.RepoSlug, "digital_objects", metsFilename)
+
+	//reformat the mets, the mets is written as exported if it can not be reformatted
+	if exportOptions.Reformat == true {
+		reformattedBytes, err := ReformatXML(metsBytes, exportOptions.Indent)
+		if err != nil {
+			LogOnly(fmt.Sprintf("[worker %d] could not reformat %s: %s", workerID, metsPath, err.Error()), WARNING)
+		} else {
+			metsBytes = reformattedBytes
+		}
+	}
+
+	//write the mets file
+	if err := WriteFileAtomic(metsPath, metsBytes, 0777); err != nil {
+		LogOnly(fmt.Sprintf("[worker %d] could not write the mets file %s", workerID, doURI), ERROR)
+		result.Status = "ERROR"
+		result.Error = err.Error()
+		return result
+	}
+
+	LogOnly(fmt.Sprintf("[worker %d] exported digital object %s - %s", workerID, doURI, metsFilename), INFO)
+	result.Status = "SUCCESS"
+	result.Path = metsPath
+	result.Size = int64(len(metsBytes))
+	return result
+}
@@ -1,12 +1,15 @@
 package aspace_xport
 
 import (
-	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"os"
-	"os/exec"
+	"io"
+	"net/http"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/nyudlts/go-aspace"
@@ -14,6 +17,7 @@ import (
 
 var (
 	numSkipped    = 0
+	numRemaining  = 0
 	reportFile    string
 	results       []ExportResult
 	startTime     time.Time
@@ -26,17 +30,34 @@ var (
 const (
 	EAD ExportFormat = iota
 	MARC
+	EAD3
+	MODS
+	DC
+	PDF
+	JSON
+	LABELS
+	MARCJSON
 	UNSUPPORTED
 )
 
 type ExportOptions struct {
-	WorkDir              string
-	Format               ExportFormat
-	UnpublishedNotes     bool
-	UnpublishedResources bool
-	Workers              int
-	Reformat             bool
-	Timestamp            string
+	WorkDir              string          `json:"work_dir"`
+	Formats              []ExportFormat  `json:"formats"`
+	UnpublishedNotes     bool            `json:"unpublished_notes"`
+	UnpublishedResources bool            `json:"unpublished_resources"`
+	Workers              int             `json:"workers"`
+	Reformat             bool            `json:"reformat"`
+	Indent               string          `json:"indent"`
+	Validate             bool            `json:"validate"`
+	PDFTimeout           time.Duration   `json:"pdf_timeout"`
+	IncludeTree          bool            `json:"include_tree"`
+	DigitalObjectDMD     string          `json:"digital_object_dmd"`
+	MARCOutputs          []string        `json:"marc_outputs"`
+	Filters              ResourceFilters `json:"filters"`
+	Timestamp            string          `json:"timestamp"`
+	MARCFileTimestamp    string          `json:"marc_file_timestamp"`
+	ReportFormats        []string        `json:"report_formats"`
+	Resume               bool            `json:"-"`
 }
 
 type ExportFormat int
@@ -47,32 +68,227 @@ func GetExportFormat(xportFormat string) (ExportFormat, error) {
 		return EAD, nil
 	case "marc":
 		return MARC, nil
+	case "ead3":
+		return EAD3, nil
+	case "mods":
+		return MODS, nil
+	case "dc":
+		return DC, nil
+	case "pdf":
+		return PDF, nil
+	case "json":
+		return JSON, nil
+	case "labels":
+		return LABELS, nil
+	case "marc-json":
+		return MARCJSON, nil
 	default:
-		return UNSUPPORTED, fmt.Errorf("unsupported format error, %s, supported formats are `ead` or `marc`")
+		return UNSUPPORTED, fmt.Errorf("unsupported format error, %s, supported formats are `ead`, `ead3`, `marc`, `marc-json`, `mods`, `dc`, `pdf`, `json` or `labels`", xportFormat)
 	}
 }
 
+// parse a comma separated list of export formats, each format is only exported once
+func GetExportFormats(xportFormats string) ([]ExportFormat, error) {
+	formats := []ExportFormat{}
+	for _, f := range strings.Split(xportFormats, ",") {
+		f = strings.TrimSpace(f)
+		if f == "" {
+			continue
+		}
+		format, err := GetExportFormat(f)
+		if err != nil {
+			return formats, err
+		}
+		if !containsFormat(formats, format) {
+			formats = append(formats, format)
+		}
+	}
+	if len(formats) == 0 {
+		return formats, fmt.Errorf("no export format set")
+	}
+	return formats, nil
+}
+
+func containsFormat(formats []ExportFormat, format ExportFormat) bool {
+	for _, f := range formats {
+		if f == format {
+			return true
+		}
+	}
+	return false
+}
+
+func (f ExportFormat) String() string {
+	switch f {
+	case EAD:
+		return "ead"
+	case MARC:
+		return "marc"
+	case EAD3:
+		return "ead3"
+	case MODS:
+		return "mods"
+	case DC:
+		return "dc"
+	case PDF:
+		return "pdf"
+	case JSON:
+		return "json"
+	case LABELS:
+		return "labels"
+	case MARCJSON:
+		return "marc-json"
+	default:
+		return "unsupported"
+	}
+}
+
+// formats are written to the journal by name
+func (f ExportFormat) MarshalText() ([]byte, error) {
+	return []byte(f.String()), nil
+}
+
+func (f *ExportFormat) UnmarshalText(text []byte) error {
+	format, err := GetExportFormat(string(text))
+	if err != nil {
+		return err
+	}
+	*f = format
+	return nil
+}
+
+// the result of a resource or other record, the status is the least successful status of its formats
 type ExportResult struct {
-	Status string
-	URI    string
-	Error  string
+	Type       string         `json:"type,omitempty"`
+	Status     string         `json:"status"`
+	URI        string         `json:"uri"`
+	Error      string         `json:"error,omitempty"`
+	Reason     string         `json:"reason,omitempty"`
+	RepoID     int            `json:"repo_id"`
+	RepoSlug   string         `json:"repo_slug"`
+	ResourceID int            `json:"resource_id"`
+	Attempts   int            `json:"attempts"`
+	EADID      string         `json:"eadid,omitempty"`
+	Path       string         `json:"path,omitempty"`
+	Size       int64          `json:"size"`
+	Duration   time.Duration  `json:"duration"`
+	Formats    []FormatResult `json:"formats,omitempty"`
+}
+
+// the result of exporting a resource in one format
+type FormatResult struct {
+	Format   ExportFormat `json:"format"`
+	Status   string       `json:"status"`
+	Error    string       `json:"error,omitempty"`
+	Reason   string       `json:"reason,omitempty"`
+	Attempts int          `json:"attempts"`
+	Path     string       `json:"path,omitempty"`
+	Size     int64        `json:"size"`
 }
 
-func ExportResources(options ExportOptions, stTime time.Time, fTime string, resInfo *[]ResourceInfo) error {
+// the order of statuses from most to least successful
+var statusRank = map[string]int{"SUCCESS": 0, "SKIPPED": 1, "WARNING": 2, "ERROR": 3}
+
+// export the resources, when the context is cancelled no more resources are started, the exports in progress
+// are finished and a report of the completed exports is written
+func ExportResources(ctx context.Context, options ExportOptions, stTime time.Time, fTime string, resInfo *[]ResourceInfo) error {
 	exportOptions = options
 	startTime = stTime
 	formattedTime = fTime
 	resourceInfo = resInfo
-	resourceChunks := chunkResources()
-	resultChannel := make(chan []ExportResult)
 
-	for i, chunk := range resourceChunks {
-		go exportChunk(chunk, resultChannel, i+1)
+	if exportOptions.Workers < 1 {
+		exportOptions.Workers = 1
+	}
+	if len(exportOptions.MARCOutputs) == 0 {
+		exportOptions.MARCOutputs = []string{MARCXMLOutput}
+	}
+
+	//the repository marc files keep the timestamp of the first run so a resumed export appends to the same files
+	if exportOptions.MARCFileTimestamp == "" {
+		exportOptions.MARCFileTimestamp = formattedTime
 	}
 
-	for range resourceChunks {
-		chunk := <-resultChannel
-		results = append(results, chunk...)
+	//open the journal that each result is written to as it is received
+	journal, err := openJournal(exportOptions.WorkDir)
+	if err != nil {
+		return fmt.Errorf("could not open journal: %s", err.Error())
+	}
+	defer journal.Close()
+
+	if exportOptions.Resume == true {
+		if err := writeJournalEntry(journal, journalEntry{Event: journalResume}); err != nil {
+			return fmt.Errorf("could not write to journal: %s", err.Error())
+		}
+		reopenMARCFiles(partialMARCFiles)
+	} else {
+		if err := writeJournalEntry(journal, journalEntry{Event: journalStart, Options: &exportOptions}); err != nil {
+			return fmt.Errorf("could not write to journal: %s", err.Error())
+		}
+		for i := range *resourceInfo {
+			if err := writeJournalEntry(journal, journalEntry{Event: journalQueued, Resource: &(*resourceInfo)[i]}); err != nil {
+				return fmt.Errorf("could not write to journal: %s", err.Error())
+			}
+		}
+	}
+
+	//start the workers
+	jobs := make(chan ResourceInfo)
+	resultChannel := make(chan jobResult)
+	var wg sync.WaitGroup
+	for i := 1; i <= exportOptions.Workers; i++ {
+		wg.Add(1)
+		go exportWorker(ctx, jobs, resultChannel, i, &wg)
+	}
+
+	//queue the resources until they have all been queued or the export is interrupted
+	go func() {
+		defer close(jobs)
+		for _, rInfo := range *resourceInfo {
+			if ctx.Err() != nil {
+				return
+			}
+			select {
+			case jobs <- rInfo:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	//close the result channel once every worker has finished
+	go func() {
+		wg.Wait()
+		close(resultChannel)
+	}()
+
+	//collect the results as they are completed
+	completed := 0
+	for job := range resultChannel {
+		result := job.Result
+		results = append(results, result)
+		if result.Status == "SKIPPED" {
+			numSkipped = numSkipped + 1
+		}
+
+		if err := writeJournalEntry(journal, journalEntry{Event: journalCompleted, Resource: &job.Resource, Result: &result}); err != nil {
+			LogOnly(fmt.Sprintf("could not write result for %s to journal: %s", result.URI, err.Error()), WARNING)
+		}
+
+		completed++
+		if completed%50 == 0 {
+			PrintOnly(fmt.Sprintf("completed %d of %d exports", completed, len(*resourceInfo)), INFO)
+		}
+	}
+
+	numRemaining = len(*resourceInfo) - completed
+	if numRemaining > 0 {
+		PrintAndLog(fmt.Sprintf("export interrupted, %d resources were not exported", numRemaining), WARNING)
+	}
+
+	//finish the repository marc files before the report that lists them
+	if err := closeMARCFiles(journal, numRemaining > 0); err != nil {
+		PrintAndLog(err.Error(), ERROR)
 	}
 
 	if err := CreateReport(); err != nil {
@@ -82,179 +298,493 @@ func ExportResources(options ExportOptions, stTime time.Time, fTime string, resI
 	return nil
 }
 
-func chunkResources() [][]ResourceInfo {
-	var divided [][]ResourceInfo
-	ri := *resourceInfo
-	chunkSize := (len(ri) + exportOptions.Workers - 1) / exportOptions.Workers
+// the result of a job with the resource it was queued as, agents are queued with the repository they are exported
+// from but reported under their own repository ID and slug
+type jobResult struct {
+	Resource ResourceInfo
+	Result   ExportResult
+}
 
-	for i := 0; i < len(*resourceInfo); i += chunkSize {
-		end := i + chunkSize
+func exportWorker(ctx context.Context, jobs <-chan ResourceInfo, resultChannel chan<- jobResult, workerID int, wg *sync.WaitGroup) {
+	defer wg.Done()
+	PrintAndLog(fmt.Sprintf("starting [worker %d]", workerID), INFO)
+
+	//pull records off the queue until it is empty
+	processed := 0
+	for rInfo := range jobs {
+		start := time.Now()
+		var result ExportResult
+		switch rInfo.Type {
+		case DigitalObjectType:
+			result = exportDigitalObject(ctx, rInfo, workerID)
+		case AgentPersonType, AgentCorporateEntityType, AgentFamilyType:
+			result = exportAgent(ctx, rInfo, workerID)
+		default:
+			result = exportResource(ctx, rInfo, workerID)
+		}
+		result.Duration = time.Since(start)
+		result.Type = rInfo.Type
+		result.RepoID = rInfo.RepoID
+		result.RepoSlug = rInfo.RepoSlug
+		if isAgentType(rInfo.Type) {
+			result.RepoID = AgentsRepoID
+			result.RepoSlug = AgentsRepoSlug
+		}
+		result.ResourceID = rInfo.ResourceID
+		resultChannel <- jobResult{Resource: rInfo, Result: result}
+		processed++
+	}
 
-		if end > len(*resourceInfo) {
-			end = len(*resourceInfo)
+	PrintAndLog(fmt.Sprintf("[worker %d] finished, processed %d records", workerID, processed), INFO)
+}
+
+func exportResource(ctx context.Context, rInfo ResourceInfo, workerID int) ExportResult {
+	//get the resource record once for every format, the crosswalked formats need its linked records resolved
+	crosswalk := containsFormat(exportOptions.Formats, MODS) || containsFormat(exportOptions.Formats, DC)
+	resourceURI := fmt.Sprintf("/repositories/%d/resources/%d", rInfo.RepoID, rInfo.ResourceID)
+	recordBytes, attempts, err := getResourceRecord(ctx, rInfo, crosswalk)
+	var description resourceDescription
+	if err == nil {
+		err = json.Unmarshal(recordBytes, &description)
+	}
+	if err != nil {
+		PrintAndLog(fmt.Sprintf("[worker %d] could not retrieve %s, code: %s, attempts: %d", workerID, resourceURI, err.Error(), attempts), ERROR)
+		return ExportResult{Status: "ERROR", URI: fmt.Sprintf("repositories/%d/resources/%d", rInfo.RepoID, rInfo.ResourceID), Error: err.Error(), Attempts: attempts}
+	}
+	res := &description.Resource
+
+	//check if the resource is set to be published
+	if exportOptions.UnpublishedResources == false && res.Publish != true {
+		LogOnly(fmt.Sprintf("[worker %d]  resource %s not set to publish, skipping", workerID, res.URI), INFO)
+		return ExportResult{Status: "SKIPPED", URI: res.URI, Error: "", Attempts: attempts, EADID: res.EADID}
+	}
+
+	//check that the resource passes the filters set in the export options
+	reason, detail, err := exportOptions.Filters.apply(recordBytes)
+	if err != nil {
+		PrintAndLog(fmt.Sprintf("[worker %d] could not read the record of %s: %s", workerID, res.URI, err.Error()), ERROR)
+		return ExportResult{Status: "ERROR", URI: res.URI, Error: err.Error(), Attempts: attempts, EADID: res.EADID}
+	}
+	if reason != "" {
+		LogOnly(fmt.Sprintf("[worker %d]  resource %s did not pass a filter, skipping: %s", workerID, res.URI, detail), INFO)
+		return ExportResult{Status: "SKIPPED", URI: res.URI, Error: detail, Reason: reason, Attempts: attempts, EADID: res.EADID}
+	}
+
+	//get the marc xml once for the marc and marc-json formats
+	var marcXML resourceMARCXML
+	if containsFormat(exportOptions.Formats, MARC) || containsFormat(exportOptions.Formats, MARCJSON) {
+		marcXML = getMARCXML(ctx, rInfo, *res)
+	}
+
+	//export each format, the resource has the least successful status of its formats
+	result := ExportResult{Status: "SUCCESS", URI: res.URI, EADID: res.EADID, Attempts: attempts}
+	for _, format := range exportOptions.Formats {
+		var formatResult FormatResult
+		switch format {
+		case MARC:
+			formatResult = exportMarc(rInfo, *res, marcXML, workerID)
+		case EAD, EAD3:
+			formatResult = exportEAD(ctx, rInfo, *res, format, workerID)
+		case MODS, DC:
+			formatResult = exportCrosswalk(rInfo, *res, description, format, workerID)
+		case PDF:
+			formatResult = exportPDF(ctx, rInfo, *res, workerID)
+		case JSON:
+			formatResult = exportJSON(ctx, rInfo, *res, recordBytes, workerID)
+		case LABELS:
+			formatResult = exportLabels(ctx, rInfo, *res, workerID)
+		case MARCJSON:
+			formatResult = exportMARCJSON(rInfo, *res, marcXML, workerID)
+		default:
+			//there's an unsupported format, this shouldn't be possible
+			formatResult = FormatResult{Status: "ERROR", Error: "unsupported export format"}
+		}
+		formatResult.Format = format
+		result.Formats = append(result.Formats, formatResult)
+
+		if statusRank[formatResult.Status] > statusRank[result.Status] {
+			result.Status = formatResult.Status
 		}
+		result.Size = result.Size + formatResult.Size
 
-		divided = append(divided, ri[i:end])
+		//record the most attempts any request for the resource needed
+		if formatResult.Attempts > result.Attempts {
+			result.Attempts = formatResult.Attempts
+		}
 	}
-	return divided
+	return result
 }
 
-func exportChunk(resourceInfoChunk []ResourceInfo, resultChannel chan []ExportResult, workerID int) {
-	PrintAndLog(fmt.Sprintf("starting [worker %d] processing %d resources", workerID, len(resourceInfoChunk)), INFO)
-	var results = []ExportResult{}
+// get the json of a resource record, with its linked agents, subjects and repository resolved if set
+func getResourceRecord(ctx context.Context, info ResourceInfo, resolve bool) ([]byte, int, error) {
+	endpoint := fmt.Sprintf("/repositories/%d/resources/%d", info.RepoID, info.ResourceID)
+	if resolve {
+		endpoint = endpoint + "?resolve[]=linked_agents&resolve[]=subjects&resolve[]=repository"
+	}
+
+	return getJSON(ctx, endpoint)
+}
+
+// get the directory a format is written to in a repository directory, when more than one format is exported
+// each format is written to its own subdirectory
+func formatDirectory(info ResourceInfo, dir string, format ExportFormat) string {
+	if len(exportOptions.Formats) > 1 {
+		return filepath.Join(exportOptions.WorkDir, info.RepoSlug, dir, format.String())
+	}
+	return filepath.Join(exportOptions.WorkDir, info.RepoSlug, dir)
+}
 
-	//loop through the chunk
-	for i, rInfo := range resourceInfoChunk {
+// the marc xml of a resource, retrieved once and shared by the marc and marc-json formats
+type resourceMARCXML struct {
+	Bytes    []byte
+	Attempts int
+	Duration time.Duration
+	Err      error
+}
 
-		if i > 1 && (i-1)%50 == 0 {
-			PrintOnly(fmt.Sprintf("[worker %d] has completed %d exports", workerID, i-1), INFO)
+// get the marc xml of a resource
+func getMARCXML(ctx context.Context, info ResourceInfo, res aspace.Resource) resourceMARCXML {
+	startTime := time.Now()
+	marcXML := resourceMARCXML{}
+	marcXML.Attempts, marcXML.Err = withRetry(ctx, fmt.Sprintf("%s as marc xml", res.URI), func() error {
+		var err error
+		marcXML.Bytes, err = client.GetMARCAsByteArray(info.RepoID, info.ResourceID, exportOptions.UnpublishedNotes)
+		return err
+	})
+	marcXML.Duration = time.Since(startTime)
+	return marcXML
+}
+
+// get the base of the output filenames of a resource, its EADID or its identifiers if it does not have an EADID
+func getBaseFilename(res aspace.Resource, workerID int) string {
+	if res.EADID == "" {
+		LogOnly(fmt.Sprintf("[worker %d] resource %s does not have an EADID, using resourceIDs for filename", workerID, res.URI), WARNING)
+		return strings.ToLower(MergeIDs(res))
+	}
+	return res.EADID
+}
+
+// get the path to write a format of a resource to, unpublished resources are written to the unpublished directory
+func getOutputPath(info ResourceInfo, res aspace.Resource, format ExportFormat, filename string) string {
+	if exportOptions.UnpublishedResources == true && res.Publish == false {
+		return filepath.Join(formatDirectory(info, "unpublished", format), filename)
+	}
+	return filepath.Join(formatDirectory(info, "exports", format), filename)
+}
+
+// validate an export if validation is set, an export that does not validate is written to the failures directory.
+// Returns the path to write the export to and the validation error
+func validateExport(info ResourceInfo, res aspace.Resource, format ExportFormat, path string, exportBytes []byte, validate func([]byte) error, workerID int) (string, error) {
+	if exportOptions.Validate == false {
+		return path, nil
+	}
+	if err := validate(exportBytes); err != nil {
+		LogOnly(fmt.Sprintf("[worker %d] %s did not validate, writing to failures directory", workerID, res.URI), WARNING)
+		return filepath.Join(formatDirectory(info, "failures", format), filepath.Base(path)), err
+	}
+	return path, nil
+}
+
+// get the result of an exported format, a warning if the export did not validate
+func getExportedResult(res aspace.Resource, filename string, path string, size int64, attempts int, validationErr error, workerID int) FormatResult {
+	if validationErr != nil {
+		LogOnly(fmt.Sprintf("[worker %d]  exported resource %s - %s with warning", workerID, res.URI, filename), WARNING)
+		return FormatResult{Status: "WARNING", Error: validationErr.Error(), Reason: GetValidationReason(validationErr), Attempts: attempts, Path: path, Size: size}
+	}
+	LogOnly(fmt.Sprintf("[worker %d] exported resource %s - %s", workerID, res.URI, filename), INFO)
+	return FormatResult{Status: "SUCCESS", Error: "", Attempts: attempts, Path: path, Size: size}
+}
+
+func exportMarc(info ResourceInfo, res aspace.Resource, marcXML resourceMARCXML, workerID int) FormatResult {
+	//check the marc record was retrieved
+	marcBytes, attempts, err := marcXML.Bytes, marcXML.Attempts, marcXML.Err
+	if err != nil {
+		PrintAndLog(fmt.Sprintf("[worker %d] could not retrieve %s as marc xml, code: %s, time: %s, attempts: %d", workerID, res.URI, err.Error(), marcXML.Duration.Truncate(time.Second).String(), attempts), ERROR)
+		return FormatResult{Status: "ERROR", Error: err.Error(), Attempts: attempts}
+	}
+
+	//create the output filename
+	baseFilename := getBaseFilename(res, workerID)
+
+	marcFilename := strings.ToLower(fmt.Sprintf("%s_%s.xml", baseFilename, formattedTime))
+
+	//set the location to write the marc record and validate the output
+	marcPath, validationErr := validateExport(info, res, MARC, getOutputPath(info, res, MARC, marcFilename), marcBytes, ValidateMARC, workerID)
+	warning := validationErr != nil
+
+	//append the records to the repository marc files, records that did not validate are only written to the failures directory
+	size := int64(0)
+	repositoryPaths := []string{}
+	for _, output := range exportOptions.MARCOutputs {
+		if output == MARCXMLOutput || warning == true {
+			continue
+		}
+		records, err := parseMARCXML(marcBytes)
+		if err == nil && len(records) == 0 {
+			err = fmt.Errorf("no marc records")
 		}
-		//get the resource object
-		var res *aspace.Resource
-		res, err := client.GetResource(rInfo.RepoID, rInfo.ResourceID)
 		if err != nil {
-			PrintAndLog(fmt.Sprintf("[worker %d] could not retrieve /repositories/%d/resources/%d, code: %s", workerID, rInfo.RepoID, rInfo.ResourceID, err.Error()), ERROR)
-			results = append(results, ExportResult{Status: "ERROR", URI: fmt.Sprintf("repositories/%d/resources/%d", rInfo.RepoID, rInfo.ResourceID), Error: err.Error()})
-			continue
+			PrintAndLog(fmt.Sprintf("[worker %d] could not read the marc records of %s: %s", workerID, res.URI, err.Error()), ERROR)
+			return FormatResult{Status: "ERROR", Error: err.Error(), Attempts: attempts}
 		}
+		repositoryPath := getMARCFilePath(info, res, output)
+		appendedSize, err := appendMARCRecords(repositoryPath, output, res.URI, records)
+		if err != nil {
+			PrintAndLog(fmt.Sprintf("[worker %d] could not add %s to %s: %s", workerID, res.URI, repositoryPath, err.Error()), ERROR)
+			return FormatResult{Status: "ERROR", Error: err.Error(), Attempts: attempts}
+		}
+		repositoryPaths = append(repositoryPaths, repositoryPath)
+		size = size + appendedSize
+	}
 
-		//check if the resource is set to be published
-		if exportOptions.UnpublishedResources == false && res.Publish != true {
-			LogOnly(fmt.Sprintf("[worker %d]  resource %s not set to publish, skipping", workerID, res.URI), INFO)
-			numSkipped = numSkipped + 1
-			results = append(results, ExportResult{Status: "SKIPPED", URI: res.URI, Error: ""})
-			continue
+	//write the marc file, unless the records are only written to the repository marc files
+	if containsString(exportOptions.MARCOutputs, MARCXMLOutput) || warning == true {
+		//reformat the marc record, the record is written as exported if it can not be reformatted
+		if exportOptions.Reformat == true {
+			reformattedBytes, err := ReformatXML(marcBytes, exportOptions.Indent)
+			if err != nil {
+				LogOnly(fmt.Sprintf("[worker %d] could not reformat %s: %s", workerID, marcPath, err.Error()), WARNING)
+			} else {
+				marcBytes = reformattedBytes
+			}
 		}
 
-		switch exportOptions.Format {
-		case MARC:
-			results = append(results, exportMarc(rInfo, *res, workerID))
-		case EAD:
-			results = append(results, exportEAD(rInfo, *res, workerID))
-		default:
-			//there's an unsupported format, this shouldn't be possible
+		err = WriteFileAtomic(marcPath, marcBytes, 0777)
+		if err != nil {
+			LogOnly(fmt.Sprintf("[worker %d]  could not write the marc record %s", workerID, res.URI), ERROR)
+			return FormatResult{Status: "ERROR", Error: err.Error(), Attempts: attempts}
 		}
+		size = size + int64(len(marcBytes))
+	} else {
+		marcPath = repositoryPaths[0]
+		marcFilename = filepath.Base(marcPath)
 	}
 
-	PrintAndLog(fmt.Sprintf("[worker %d] finished, processed %d resources", workerID, len(results)), INFO)
-	resultChannel <- results
+	//return the result
+	return getExportedResult(res, marcFilename, marcPath, size, attempts, validationErr, workerID)
 }
 
-func exportMarc(info ResourceInfo, res aspace.Resource, workerID int) ExportResult {
-	startTime := time.Now()
+// export the marc record of a resource as marc-in-json
+func exportMARCJSON(info ResourceInfo, res aspace.Resource, marcXML resourceMARCXML, workerID int) FormatResult {
+	//check the marc record was retrieved
+	marcBytes, attempts, err := marcXML.Bytes, marcXML.Attempts, marcXML.Err
+	if err != nil {
+		PrintAndLog(fmt.Sprintf("[worker %d] could not retrieve %s as marc xml, code: %s, attempts: %d", workerID, res.URI, err.Error(), attempts), ERROR)
+		return FormatResult{Status: "ERROR", Error: err.Error(), Attempts: attempts}
+	}
 
-	var marcBytes []byte
-	var err error
-	//get the marc record
-	marcBytes, err = client.GetMARCAsByteArray(info.RepoID, info.ResourceID, exportOptions.UnpublishedNotes)
+	//convert the marc xml to marc-in-json
+	jsonBytes, err := marcXMLToJSON(marcBytes, exportOptions.Indent)
 	if err != nil {
-		errorTime := time.Since(startTime)
-		PrintAndLog(fmt.Sprintf("[worker %d] could not retrieve %s as marc xml, code: %s, time: %s", workerID, res.URI, err.Error(), errorTime.Truncate(time.Second).String()), ERROR)
-		return ExportResult{Status: "ERROR", URI: res.URI, Error: err.Error()}
+		PrintAndLog(fmt.Sprintf("[worker %d] could not convert the marc record of %s to marc-in-json: %s", workerID, res.URI, err.Error()), ERROR)
+		return FormatResult{Status: "ERROR", Error: err.Error(), Attempts: attempts}
 	}
 
 	//create the output filename
-	var baseFilename string
-	if res.EADID == "" {
-		baseFilename = strings.ToLower(MergeIDs(res))
-		LogOnly(fmt.Sprintf("[worker %d] resource %s does not have an EADID, using resourceIDs for filename", workerID, res.URI), WARNING)
+	baseFilename := getBaseFilename(res, workerID)
+	jsonFilename := strings.ToLower(fmt.Sprintf("%s_%s.json", baseFilename, formattedTime))
 
-	} else {
-		baseFilename = res.EADID
+	//set the location to write the marc-in-json record, validating the marc xml the record was converted from
+	jsonPath, validationErr := validateExport(info, res, MARCJSON, getOutputPath(info, res, MARCJSON, jsonFilename), marcBytes, ValidateMARC, workerID)
+
+	//write the marc-in-json file
+	if err := WriteFileAtomic(jsonPath, jsonBytes, 0777); err != nil {
+		LogOnly(fmt.Sprintf("[worker %d]  could not write the marc-in-json record %s", workerID, res.URI), ERROR)
+		return FormatResult{Status: "ERROR", Error: err.Error(), Attempts: attempts}
 	}
 
-	marcFilename := strings.ToLower(fmt.Sprintf("%s_%s.xml", baseFilename, formattedTime))
-	//set the location to write the marc record
-	var marcPath string
-	if exportOptions.UnpublishedResources == true && res.Publish == false {
-		marcPath = filepath.Join(exportOptions.WorkDir, info.RepoSlug, "unpublished", marcFilename)
-	} else {
-		marcPath = filepath.Join(exportOptions.WorkDir, info.RepoSlug, "exports", marcFilename)
+	//return the result
+	return getExportedResult(res, jsonFilename, jsonPath, int64(len(jsonBytes)), attempts, validationErr, workerID)
+}
+
+func exportEAD(ctx context.Context, info ResourceInfo, res aspace.Resource, format ExportFormat, workerID int) FormatResult {
+
+	//get the ead or ead3 as bytes
+	ead3 := format == EAD3
+	var eadBytes []byte
+	attempts, err := withRetry(ctx, fmt.Sprintf("%s as %s", res.URI, format), func() error {
+		var err error
+		if ead3 {
+			eadBytes, err = client.SerializeEAD(info.RepoID, info.ResourceID, true, exportOptions.UnpublishedNotes, false, true, false)
+		} else {
+			eadBytes, err = client.GetEADAsByteArray(info.RepoID, info.ResourceID, exportOptions.UnpublishedNotes)
+		}
+		return err
+	})
+	if err != nil {
+		LogOnly(fmt.Sprintf("INFO [worker %d] could not retrieve resource %s, attempts: %d", workerID, res.URI, attempts), ERROR)
+		return FormatResult{Status: "ERROR", Error: err.Error(), Attempts: attempts}
 	}
 
+	//create the output filename, with the same base filename as the other formats
+	eadFilename := fmt.Sprintf("%s.xml", getBaseFilename(res, workerID))
+
 	//validate the output
-	warning := false
-	var warningType = ""
+	validateEAD := ValidateEAD
+	if ead3 {
+		validateEAD = ValidateEAD3
+	}
+	outputFile, validationErr := validateExport(info, res, format, filepath.Join(formatDirectory(info, "exports", format), eadFilename), eadBytes, validateEAD, workerID)
 
-	//write the marc file
-	err = os.WriteFile(marcPath, marcBytes, 0777)
+	//reformat the ead, the ead is written as exported if it can not be reformatted
+	if exportOptions.Reformat == true {
+		reformattedBytes, err := ReformatXML(eadBytes, exportOptions.Indent)
+		if err != nil {
+			LogOnly(fmt.Sprintf("[worker %d] could not reformat %s: %s", workerID, outputFile, err.Error()), WARNING)
+		} else {
+			eadBytes = reformattedBytes
+		}
+	}
+
+	//create the output file
+	err = WriteFileAtomic(outputFile, eadBytes, 0777)
 	if err != nil {
-		LogOnly(fmt.Sprintf("[worker %d]  could not write the marc record %s", workerID, res.URI), ERROR)
-		return ExportResult{Status: "ERROR", URI: "", Error: err.Error()}
+		LogOnly(fmt.Sprintf("[worker %d] could not write the ead file %s", workerID, res.URI), ERROR)
+		return FormatResult{Status: "ERROR", Error: err.Error(), Attempts: attempts}
 	}
 
 	//return the result
-	if warning == true {
-		LogOnly(fmt.Sprintf("[worker %d]  exported resource %s - %s with warning", workerID, res.URI, marcFilename), WARNING)
-		return ExportResult{Status: "WARNING", URI: res.URI, Error: warningType}
-	}
-	LogOnly(fmt.Sprintf("[worker %d] exported resource %s - %s", workerID, res.URI, baseFilename), INFO)
-	return ExportResult{Status: "SUCCESS", URI: res.URI, Error: ""}
+	return getExportedResult(res, eadFilename, outputFile, int64(len(eadBytes)), attempts, validationErr, workerID)
 }
 
-func exportEAD(info ResourceInfo, res aspace.Resource, workerID int) ExportResult {
+// export a resource as mods or dublin core crosswalked from the resource record
+func exportCrosswalk(info ResourceInfo, res aspace.Resource, description resourceDescription, format ExportFormat, workerID int) FormatResult {
 
-	//get the ead as bytes
-	eadBytes, err := client.GetEADAsByteArray(info.RepoID, info.ResourceID, exportOptions.UnpublishedNotes)
+	//crosswalk the record
+	var recordBytes []byte
+	var err error
+	if format == MODS {
+		recordBytes, err = description.toMODS()
+	} else {
+		recordBytes, err = description.toDC()
+	}
 	if err != nil {
-		LogOnly(fmt.Sprintf("INFO [worker %d] could not retrieve resource %s", workerID, res.URI), ERROR)
-		return ExportResult{Status: "ERROR", URI: res.URI, Error: err.Error()}
+		PrintAndLog(fmt.Sprintf("[worker %d] could not create %s record for %s: %s", workerID, format, res.URI, err.Error()), ERROR)
+		return FormatResult{Status: "ERROR", Error: err.Error()}
 	}
 
 	//create the output filename
-	eadFilename := fmt.Sprintf("%s.xml", res.EADID)
-	outputFile := filepath.Join(exportOptions.WorkDir, info.RepoSlug, "exports", eadFilename)
+	baseFilename := getBaseFilename(res, workerID)
+	recordFilename := fmt.Sprintf("%s.xml", baseFilename)
 
-	//validate the output
-	warning := false
-	var warningType = ""
+	//set the location to write the record
+	recordPath := getOutputPath(info, res, format, recordFilename)
 
-	//create the output file
-	err = os.WriteFile(outputFile, eadBytes, 0777)
+	//write the record
+	if err := WriteFileAtomic(recordPath, recordBytes, 0777); err != nil {
+		LogOnly(fmt.Sprintf("[worker %d] could not write the %s record %s", workerID, format, res.URI), ERROR)
+		return FormatResult{Status: "ERROR", Error: err.Error()}
+	}
+
+	LogOnly(fmt.Sprintf("[worker %d] exported resource %s - %s", workerID, res.URI, recordFilename), INFO)
+	return FormatResult{Status: "SUCCESS", Error: "", Path: recordPath, Size: int64(len(recordBytes))}
+}
+
+// get a resource as a pdf, the request is cancelled if archivesspace does not generate the pdf within the pdf timeout.
+// A timeout is not retried, archivesspace would generate the pdf again from the start
+func getPDF(ctx context.Context, info ResourceInfo) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, exportOptions.PDFTimeout)
+	defer cancel()
+
+	endpoint := fmt.Sprintf("/repositories/%d/resource_descriptions/%d.pdf?include_unpublished=%t&include_daos=true", info.RepoID, info.ResourceID, exportOptions.UnpublishedNotes)
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, client.RootURL+endpoint, nil)
 	if err != nil {
-		LogOnly(fmt.Sprintf("[worker %d] could not write the ead file %s", workerID, res.URI), ERROR)
-		return ExportResult{Status: "ERROR", URI: "", Error: err.Error()}
+		return nil, err
 	}
+	request.Header.Add("X-ArchivesSpace-Session", client.GetSessionKey())
 
-	//reformat the ead with tabs
-	if exportOptions.Reformat == true {
-		err = tabReformatXML(outputFile)
-		if err != nil {
-			LogOnly(fmt.Sprintf("[worker %d] could not reformat %s", workerID, outputFile), WARNING)
+	timedOut := func(err error) error {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return fmt.Errorf("pdf was not generated within %s", exportOptions.PDFTimeout)
 		}
+		return err
 	}
 
-	//return the result
+	resp, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return nil, timedOut(err)
+	}
+	defer resp.Body.Close()
+
+	//return the status code as the error like go-aspace so it is classified the same way
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%d", resp.StatusCode)
+	}
 
-	if warning == true {
-		LogOnly(fmt.Sprintf("[worker %d] exported resource %s - %s with warning", workerID, res.URI, eadFilename), WARNING)
-		return ExportResult{Status: "WARNING", URI: res.URI, Error: warningType}
+	pdfBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, timedOut(err)
 	}
-	LogOnly(fmt.Sprintf("[worker %d] exported resource %s - %s", workerID, res.URI, res.EADID), INFO)
-	return ExportResult{Status: "SUCCESS", URI: res.URI, Error: ""}
+	return pdfBytes, nil
 }
 
-func tabReformatXML(path string) error {
+func exportPDF(ctx context.Context, info ResourceInfo, res aspace.Resource, workerID int) FormatResult {
 
-	//lint the ead file
-	reformattedBytes, err := exec.Command("xmllint", "--format", path).Output()
+	//get the pdf
+	var pdfBytes []byte
+	attempts, err := withRetry(ctx, fmt.Sprintf("%s as pdf", res.URI), func() error {
+		var err error
+		pdfBytes, err = getPDF(ctx, info)
+		return err
+	})
 	if err != nil {
-		return fmt.Errorf("could not reformat %s", path)
+		PrintAndLog(fmt.Sprintf("[worker %d] could not retrieve %s as pdf, code: %s, attempts: %d", workerID, res.URI, err.Error(), attempts), ERROR)
+		return FormatResult{Status: "ERROR", Error: err.Error(), Attempts: attempts}
 	}
 
-	//delete the original
-	err = os.Remove(path)
+	//create the output filename
+	baseFilename := getBaseFilename(res, workerID)
+	pdfFilename := fmt.Sprintf("%s.pdf", baseFilename)
+
+	//validate the output
+	outputFile, validationErr := validateExport(info, res, PDF, filepath.Join(formatDirectory(info, "exports", PDF), pdfFilename), pdfBytes, ValidatePDF, workerID)
+
+	//create the output file
+	err = WriteFileAtomic(outputFile, pdfBytes, 0777)
+	if err != nil {
+		LogOnly(fmt.Sprintf("[worker %d] could not write the pdf file %s", workerID, res.URI), ERROR)
+		return FormatResult{Status: "ERROR", Error: err.Error(), Attempts: attempts}
+	}
+
+	//return the result
+	return getExportedResult(res, pdfFilename, outputFile, int64(len(pdfBytes)), attempts, validationErr, workerID)
+}
+
+// export the container labels of a resource as the tab separated file archivesspace creates for printing box labels
+func exportLabels(ctx context.Context, info ResourceInfo, res aspace.Resource, workerID int) FormatResult {
+
+	//get the labels
+	var labelBytes []byte
+	endpoint := fmt.Sprintf("/repositories/%d/resource_labels/%d.tsv", info.RepoID, info.ResourceID)
+	attempts, err := withRetry(ctx, fmt.Sprintf("%s container labels", res.URI), func() error {
+		response, err := client.GetEndpoint(endpoint)
+		if err != nil {
+			return err
+		}
+		defer response.Body.Close()
+
+		labelBytes, err = io.ReadAll(response.Body)
+		return err
+	})
 	if err != nil {
-		return fmt.Errorf("could not delete %s", path)
+		PrintAndLog(fmt.Sprintf("[worker %d] could not retrieve container labels of %s, code: %s, attempts: %d", workerID, res.URI, err.Error(), attempts), ERROR)
+		return FormatResult{Status: "ERROR", Error: err.Error(), Attempts: attempts}
 	}
 
-	//rewrite the file
-	err = os.WriteFile(path, reformattedBytes, 0644)
+	//create the output filename
+	baseFilename := getBaseFilename(res, workerID)
+	labelsFilename := fmt.Sprintf("%s_labels.tsv", baseFilename)
+	outputFile := filepath.Join(formatDirectory(info, "exports", LABELS), labelsFilename)
+
+	//create the output file
+	err = WriteFileAtomic(outputFile, labelBytes, 0777)
 	if err != nil {
-		return fmt.Errorf("could not write reformated bytes to %s", path)
+		LogOnly(fmt.Sprintf("[worker %d] could not write the labels file %s", workerID, res.URI), ERROR)
+		return FormatResult{Status: "ERROR", Error: err.Error(), Attempts: attempts}
 	}
 
-	return nil
+	LogOnly(fmt.Sprintf("[worker %d] exported resource %s - %s", workerID, res.URI, labelsFilename), INFO)
+	return FormatResult{Status: "SUCCESS", Error: "", Attempts: attempts, Path: outputFile, Size: int64(len(labelBytes))}
 }
 
 func MergeIDs(r aspace.Resource) string {
@@ -268,13 +798,75 @@ func MergeIDs(r aspace.Resource) string {
 }
 
 func CreateReport() error {
+	//seperate resources from digital objects and agents
+	resources := []ExportResult{}
+	digitalObjects := []ExportResult{}
+	agents := []ExportResult{}
+	for _, result := range results {
+		switch {
+		case result.Type == DigitalObjectType:
+			digitalObjects = append(digitalObjects, result)
+		case isAgentType(result.Type):
+			agents = append(agents, result)
+		default:
+			resources = append(resources, result)
+		}
+	}
+
+	executionTime = time.Since(startTime)
+
+	reportFile = filepath.Join(exportOptions.WorkDir, fmt.Sprintf("aspace-export-report-%s.txt", exportOptions.Timestamp))
+	msg := "ASPACE-EXPORT REPORT\n====================\n"
+	msg = msg + fmt.Sprintf("Execution Time: %v", executionTime)
+	if numRemaining > 0 {
+		msg = msg + fmt.Sprintf("\nExport interrupted, %d resources were not exported, resume with --resume %s", numRemaining, exportOptions.WorkDir)
+	}
+	if len(resources) > 0 || (len(digitalObjects) == 0 && len(agents) == 0) {
+		msg = msg + getReportSection("Resources", resources)
+	}
+	if len(digitalObjects) > 0 {
+		msg = msg + getReportSection("Digital objects", digitalObjects)
+	}
+	if len(agents) > 0 {
+		msg = msg + getReportSection("Agents", agents)
+	}
+	if len(marcFileSummaries) > 0 {
+		msg = msg + "\n\nRepository MARC files:"
+		for _, summary := range marcFileSummaries {
+			if summary.Error != "" {
+				msg = msg + fmt.Sprintf("\n  %s: %s", summary.Path, summary.Error)
+				continue
+			}
+			if summary.Incomplete {
+				msg = msg + fmt.Sprintf("\n  %s: %d records, incomplete until the export is resumed", summary.Path, summary.Records)
+				continue
+			}
+			msg = msg + fmt.Sprintf("\n  %s: %d records", summary.Path, summary.Records)
+		}
+	}
+
+	if err := WriteFileAtomic(reportFile, []byte(msg), 0644); err != nil {
+		return err
+	}
+
+	return createStructuredReports()
+}
+
+// get the section of the report for a type of record
+func getReportSection(name string, sectionResults []ExportResult) string {
 	//seperate result types
 	successes := []ExportResult{}
 	errors := []ExportResult{}
 	warnings := []ExportResult{}
 	skipped := []ExportResult{}
+	flaky := []ExportResult{}
+
+	for _, result := range sectionResults {
+		//records that only exported after retrying are reported separately from hard failures
+		if result.Attempts > 1 && result.Status != "ERROR" {
+			flaky = append(flaky, result)
+		}
 
-	for _, result := range results {
 		switch result.Status {
 		case "SUCCESS":
 			successes = append(successes, result)
@@ -288,47 +880,47 @@ func CreateReport() error {
 		}
 	}
 
-	executionTime = time.Since(startTime)
-
-	reportFile = filepath.Join(exportOptions.WorkDir, fmt.Sprintf("aspace-export-report-%s.txt", exportOptions.Timestamp))
-	report, err := os.Create(reportFile)
-	if err != nil {
-		return err
-	}
-
-	defer report.Close()
-	writer := bufio.NewWriter(report)
-	msg := "ASPACE-EXPORT REPORT\n====================\n"
-	msg = msg + fmt.Sprintf("Execution Time: %v", executionTime)
-	msg = msg + fmt.Sprintf("\n%d Resources processed:\n", len(results))
+	msg := fmt.Sprintf("\n%d %s processed:\n", len(sectionResults), name)
 	msg = msg + fmt.Sprintf("  %d Successful exports\n", len(successes))
-	msg = msg + fmt.Sprintf("  %d Skipped resources\n", len(skipped))
+	msg = msg + fmt.Sprintf("  %d Skipped %s\n", len(skipped), strings.ToLower(name))
+	for _, s := range skipped {
+		//only records skipped by a filter have a reason, unpublished records are not listed
+		if s.Reason != "" {
+			msg = msg + fmt.Sprintf("    %s: %s (%s)\n", s.URI, s.Error, s.Reason)
+		}
+	}
 	msg = msg + fmt.Sprintf("  %d Exports with warnings\n", len(warnings))
+	for _, w := range warnings {
+		msg = msg + getReportLines(w, "WARNING")
+	}
 
-	if len(warnings) > 0 {
-		for _, w := range warnings {
-			w.Error = strings.ReplaceAll(w.Error, "\n", " ")
-			msg = msg + fmt.Sprintf("    %v\n", w)
-		}
+	msg = msg + fmt.Sprintf("  %d Exports that succeeded after retrying\n", len(flaky))
+	for _, f := range flaky {
+		msg = msg + fmt.Sprintf("    %s, attempts: %d\n", f.URI, f.Attempts)
 	}
 
 	msg = msg + fmt.Sprintf("  %d Errors Encountered\n", len(errors))
-	if len(errors) > 0 {
-		for _, e := range errors {
-			e.Error = strings.ReplaceAll(e.Error, "\n", " ")
-			msg = msg + fmt.Sprintf("    %v\n", e)
-		}
+	for _, e := range errors {
+		msg = msg + getReportLines(e, "ERROR")
 	}
+	return msg
+}
 
-	_, err = writer.WriteString(msg)
-	if err != nil {
-		return err
+// get the report lines of a result, a line for each format with the status, or for the resource if it was not exported
+func getReportLines(result ExportResult, status string) string {
+	lines := ""
+	if len(result.Formats) == 0 {
+		return fmt.Sprintf("    %s: %s\n", result.URI, strings.ReplaceAll(result.Error, "\n", " "))
 	}
-
-	err = writer.Flush()
-	if err != nil {
-		return err
+	for _, f := range result.Formats {
+		if f.Status != status {
+			continue
+		}
+		line := fmt.Sprintf("    %s %s: %s", result.URI, f.Format, strings.ReplaceAll(f.Error, "\n", " "))
+		if f.Reason != "" {
+			line = line + fmt.Sprintf(" (%s)", f.Reason)
+		}
+		lines = lines + line + "\n"
 	}
-
-	return nil
+	return lines
 }
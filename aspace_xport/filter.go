@@ -0,0 +1,155 @@
+package aspace_xport
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// machine-readable reasons a resource was skipped by a filter
+const (
+	FilterFindingAidStatus = "filter-finding-aid-status"
+	FilterLevel            = "filter-level"
+	FilterCreated          = "filter-created"
+	FilterModified         = "filter-modified"
+	FilterExcluded         = "filter-excluded"
+)
+
+// filters resources must pass to be exported, a filter that is not set passes every resource
+type ResourceFilters struct {
+	FindingAidStatuses []string  `json:"finding_aid_statuses,omitempty"`
+	Levels             []string  `json:"levels,omitempty"`
+	CreatedAfter       time.Time `json:"created_after"`
+	CreatedBefore      time.Time `json:"created_before"`
+	ModifiedAfter      time.Time `json:"modified_after"`
+	ModifiedBefore     time.Time `json:"modified_before"`
+	Exclude            []string  `json:"exclude,omitempty"`
+}
+
+// the fields of a resource record the filters are applied to, go-aspace swaps the finding aid status and sponsor
+// so the record is read separately
+type filterFields struct {
+	URI              string    `json:"uri"`
+	EADID            string    `json:"ead_id"`
+	ID0              string    `json:"id_0"`
+	ID1              string    `json:"id_1"`
+	ID2              string    `json:"id_2"`
+	ID3              string    `json:"id_3"`
+	FindingAidStatus string    `json:"finding_aid_status"`
+	Level            string    `json:"level"`
+	OtherLevel       string    `json:"other_level"`
+	CreateTime       time.Time `json:"create_time"`
+	SystemMTime      time.Time `json:"system_mtime"`
+}
+
+// parse a comma separated list of filter values
+func ParseFilterValues(values string) []string {
+	parsed := []string{}
+	for _, value := range strings.Split(values, ",") {
+		if value = strings.TrimSpace(value); value != "" && !containsString(parsed, value) {
+			parsed = append(parsed, value)
+		}
+	}
+	return parsed
+}
+
+// parse a filter date, a date or a timestamp in the same formats as --modified-since
+func ParseFilterTime(name string, value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	for _, layout := range modifiedSinceLayouts {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("could not parse %s value %s, use a timestamp such as 2006-01-02 or 2006-01-02T15:04:05Z", name, value)
+}
+
+// read the resources to exclude from a file of URIs, identifiers or EADIDs, in the same formats as a resource list
+func ReadExclusionList(path string) ([]string, error) {
+	exclude := []string{}
+	entries, err := readResourceList(path)
+	if err != nil {
+		return exclude, err
+	}
+	for _, entry := range entries {
+		if len(entry.fields) > 1 && isInt(entry.fields[0]) && isInt(entry.fields[1]) {
+			exclude = append(exclude, fmt.Sprintf("/repositories/%s/resources/%s", entry.fields[0], entry.fields[1]))
+			continue
+		}
+		exclude = append(exclude, entry.fields[0])
+	}
+	return exclude, nil
+}
+
+// check if any filter is set
+func (f ResourceFilters) isSet() bool {
+	return len(f.FindingAidStatuses) > 0 || len(f.Levels) > 0 || !f.CreatedAfter.IsZero() || !f.CreatedBefore.IsZero() ||
+		!f.ModifiedAfter.IsZero() || !f.ModifiedBefore.IsZero() || len(f.Exclude) > 0
+}
+
+// apply the filters to a resource record, returns the reason and a description if the resource does not pass
+func (f ResourceFilters) apply(recordBytes []byte) (string, string, error) {
+	if !f.isSet() {
+		return "", "", nil
+	}
+
+	fields := filterFields{}
+	if err := json.Unmarshal(recordBytes, &fields); err != nil {
+		return "", "", err
+	}
+
+	//exclusions are matched against the uri, the EADID and the identifier, with its parts joined by - as ArchivesSpace displays it
+	identifier := strings.Join(nonEmpty(fields.ID0, fields.ID1, fields.ID2, fields.ID3), "-")
+	for _, excluded := range f.Exclude {
+		if uriMatch := resourceURIPattern.FindString(excluded); (uriMatch != "" && strings.TrimSuffix(uriMatch, "/") == fields.URI) ||
+			(fields.EADID != "" && excluded == fields.EADID) || (identifier != "" && excluded == identifier) {
+			return FilterExcluded, fmt.Sprintf("%s is in the exclusion list", excluded), nil
+		}
+	}
+
+	if len(f.FindingAidStatuses) > 0 && !containsString(f.FindingAidStatuses, fields.FindingAidStatus) {
+		return FilterFindingAidStatus, fmt.Sprintf("finding aid status `%s` is not %s", fields.FindingAidStatus, strings.Join(f.FindingAidStatuses, " or ")), nil
+	}
+
+	//other levels are matched by their name
+	level := fields.Level
+	if level == "otherlevel" && fields.OtherLevel != "" {
+		level = fields.OtherLevel
+	}
+	if len(f.Levels) > 0 && !containsString(f.Levels, fields.Level) && !containsString(f.Levels, level) {
+		return FilterLevel, fmt.Sprintf("level `%s` is not %s", level, strings.Join(f.Levels, " or ")), nil
+	}
+
+	if reason, ok := inRange(fields.CreateTime, f.CreatedAfter, f.CreatedBefore, "created"); !ok {
+		return FilterCreated, reason, nil
+	}
+	if reason, ok := inRange(fields.SystemMTime, f.ModifiedAfter, f.ModifiedBefore, "modified"); !ok {
+		return FilterModified, reason, nil
+	}
+
+	return "", "", nil
+}
+
+// check that a time is within a range, returning why it is not, either end of the range is open if it is not set
+func inRange(t time.Time, after time.Time, before time.Time, name string) (string, bool) {
+	if !after.IsZero() && t.Before(after) {
+		return fmt.Sprintf("%s %s, before %s", name, t.Format(time.RFC3339), after.Format(time.RFC3339)), false
+	}
+	if !before.IsZero() && !t.Before(before) {
+		return fmt.Sprintf("%s %s, not before %s", name, t.Format(time.RFC3339), before.Format(time.RFC3339)), false
+	}
+	return "", true
+}
+
+func nonEmpty(values ...string) []string {
+	filtered := []string{}
+	for _, value := range values {
+		if value != "" {
+			filtered = append(filtered, value)
+		}
+	}
+	return filtered
+}
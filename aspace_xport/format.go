@@ -0,0 +1,170 @@
+package aspace_xport
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const cdataPrefix = "<![CDATA["
+
+// get the indent string for an --indent value, either `tab` or a number of spaces
+func GetIndent(indent string) (string, error) {
+	if indent == "tab" {
+		return "\t", nil
+	}
+
+	spaces, err := strconv.Atoi(indent)
+	if err != nil || spaces < 0 || spaces > 16 {
+		return "", fmt.Errorf("unsupported indent %s, use `tab` or a number of spaces from 0 to 16", indent)
+	}
+	return strings.Repeat(" ", spaces), nil
+}
+
+// a token and the bytes it was read from
+type rawToken struct {
+	token xml.Token
+	raw   []byte
+}
+
+// read the tokens of an xml document, calling fn with each token and its bytes in the document
+func readRawTokens(xmlBytes []byte, fn func(rawToken) error) error {
+	decoder := xml.NewDecoder(bytes.NewReader(xmlBytes))
+	offset := int64(0)
+	for {
+		token, err := decoder.RawToken()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		end := decoder.InputOffset()
+		if err := fn(rawToken{token: token, raw: xmlBytes[offset:end]}); err != nil {
+			return err
+		}
+		offset = end
+	}
+}
+
+// reformat an xml document, indenting elements that only contain other elements. The content of elements with text,
+// cdata or xml:space="preserve" is written as it is, as are the declaration, namespaces, comments and attributes.
+func ReformatXML(xmlBytes []byte, indent string) ([]byte, error) {
+
+	//find the elements with mixed content, indexed by the order of their start tags
+	type element struct {
+		name  xml.Name
+		index int
+	}
+	mixed := []bool{}
+	stack := []element{}
+	err := readRawTokens(xmlBytes, func(t rawToken) error {
+		switch token := t.token.(type) {
+		case xml.StartElement:
+			preserve := false
+			for _, attr := range token.Attr {
+				if attr.Name.Space == "xml" && attr.Name.Local == "space" && attr.Value == "preserve" {
+					preserve = true
+				}
+			}
+			mixed = append(mixed, preserve)
+			stack = append(stack, element{name: token.Name, index: len(mixed) - 1})
+		case xml.EndElement:
+			if len(stack) == 0 {
+				return fmt.Errorf("unexpected end element </%s>", rawName(token.Name))
+			}
+			if open := stack[len(stack)-1]; open.name != token.Name {
+				return fmt.Errorf("end element </%s> does not match <%s>", rawName(token.Name), rawName(open.name))
+			}
+			stack = stack[:len(stack)-1]
+		case xml.CharData:
+			if len(stack) > 0 && (bytes.HasPrefix(t.raw, []byte(cdataPrefix)) || len(bytes.TrimSpace(t.raw)) > 0) {
+				mixed[stack[len(stack)-1].index] = true
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(stack) > 0 {
+		return nil, fmt.Errorf("unexpected end of document, %d elements not closed", len(stack))
+	}
+
+	//write the document, elements in mixed content are written as they are until the mixed element is closed,
+	//each open element records if a child has been written on a new line
+	out := &bytes.Buffer{}
+	out.Grow(len(xmlBytes))
+	open := []bool{}
+	elementIndex := -1
+	preserveDepth := 0
+
+	newLine := func() {
+		if out.Len() > 0 {
+			out.WriteByte('\n')
+		}
+		out.WriteString(strings.Repeat(indent, len(open)))
+		if len(open) > 0 {
+			open[len(open)-1] = true
+		}
+	}
+
+	err = readRawTokens(xmlBytes, func(t rawToken) error {
+		switch t.token.(type) {
+		case xml.StartElement:
+			elementIndex++
+			if preserveDepth == 0 {
+				newLine()
+			}
+			out.Write(t.raw)
+			open = append(open, false)
+			if preserveDepth == 0 && mixed[elementIndex] {
+				preserveDepth = len(open)
+			}
+		case xml.EndElement:
+			hasChildren := open[len(open)-1]
+			open = open[:len(open)-1]
+
+			//a self closing element does not have an end tag
+			if len(t.raw) > 0 {
+				if preserveDepth == 0 && hasChildren {
+					out.WriteByte('\n')
+					out.WriteString(strings.Repeat(indent, len(open)))
+				}
+				out.Write(t.raw)
+			}
+			if preserveDepth > len(open) {
+				preserveDepth = 0
+			}
+		case xml.CharData:
+			//whitespace between elements is replaced by the indentation
+			if preserveDepth > 0 {
+				out.Write(t.raw)
+			}
+		default:
+			//comments, processing instructions and directives
+			if preserveDepth == 0 {
+				newLine()
+			}
+			out.Write(t.raw)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	out.WriteByte('\n')
+	return out.Bytes(), nil
+}
+
+func rawName(name xml.Name) string {
+	if name.Space == "" {
+		return name.Local
+	}
+	return name.Space + ":" + name.Local
+}
@@ -0,0 +1,160 @@
+package aspace_xport
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const JournalFilename = "aspace-export-journal.jsonl"
+
+// journal events
+const (
+	journalStart       = "start"
+	journalResume      = "resume"
+	journalQueued      = "queued"
+	journalCompleted   = "completed"
+	journalMARCPartial = "marc-partial"
+)
+
+// a single line of the journal
+type journalEntry struct {
+	Event    string           `json:"event"`
+	Time     time.Time        `json:"time"`
+	Options  *ExportOptions   `json:"options,omitempty"`
+	Resource *ResourceInfo    `json:"resource,omitempty"`
+	Result   *ExportResult    `json:"result,omitempty"`
+	MARCFile *partialMARCFile `json:"marc_file,omitempty"`
+}
+
+// the state of the most recent run recorded in a journal
+type Journal struct {
+	Options   ExportOptions
+	StartTime time.Time
+	Resources []ResourceInfo
+	Results   map[ResourceInfo]ExportResult
+	MARCFiles []partialMARCFile
+}
+
+func openJournal(workDir string) (*os.File, error) {
+	return os.OpenFile(filepath.Join(workDir, JournalFilename), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+}
+
+func writeJournalEntry(writer io.Writer, entry journalEntry) error {
+	entry.Time = time.Now()
+	entryBytes, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	_, err = writer.Write(append(entryBytes, '\n'))
+	return err
+}
+
+// load the most recent run from the journal in a work directory
+func LoadJournal(workDir string) (*Journal, error) {
+	journalFile, err := os.Open(filepath.Join(workDir, JournalFilename))
+	if err != nil {
+		return nil, err
+	}
+	defer journalFile.Close()
+
+	var journal *Journal
+	scanner := bufio.NewScanner(journalFile)
+	scanner.Buffer(make([]byte, 1024*1024), 16*1024*1024)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		entry := journalEntry{}
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			//a crash can leave a partial last line
+			LogOnly(fmt.Sprintf("skipping unreadable journal line %d: %s", lineNum, err.Error()), WARNING)
+			continue
+		}
+
+		switch entry.Event {
+		case journalStart:
+			journal = &Journal{StartTime: entry.Time, Results: map[ResourceInfo]ExportResult{}}
+			if entry.Options != nil {
+				journal.Options = *entry.Options
+			}
+		case journalQueued:
+			if journal != nil && entry.Resource != nil {
+				journal.Resources = append(journal.Resources, *entry.Resource)
+			}
+		case journalCompleted:
+			//the result is matched to the resource it was queued as, which differs for agents, journals written
+			//before the queued resource was recorded with the result are matched on the result
+			if journal != nil && entry.Result != nil {
+				rInfo := entry.Result.resourceInfo()
+				if entry.Resource != nil {
+					rInfo = *entry.Resource
+				}
+				journal.Results[rInfo] = *entry.Result
+			}
+		case journalResume:
+			//a resumed export takes over the incomplete marc files and records them again if it is interrupted
+			if journal != nil {
+				journal.MARCFiles = nil
+			}
+		case journalMARCPartial:
+			if journal != nil && entry.MARCFile != nil {
+				journal.MARCFiles = append(journal.MARCFiles, *entry.MARCFile)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if journal == nil {
+		return nil, fmt.Errorf("journal in %s does not contain a run to resume", workDir)
+	}
+	return journal, nil
+}
+
+// get the resources that have not completed or that completed with an error
+func (j *Journal) Remaining() []ResourceInfo {
+	remaining := []ResourceInfo{}
+	for _, rInfo := range j.Resources {
+		result, ok := j.Results[rInfo]
+		if !ok || result.Status == "ERROR" {
+			remaining = append(remaining, rInfo)
+		}
+	}
+	return remaining
+}
+
+// get the results of the resources that do not need to be exported again
+func (j *Journal) Completed() []ExportResult {
+	completed := []ExportResult{}
+	for _, rInfo := range j.Resources {
+		result, ok := j.Results[rInfo]
+		if ok && result.Status != "ERROR" {
+			completed = append(completed, result)
+		}
+	}
+	return completed
+}
+
+// resume an export from the journal in a work directory, returning the options and the resources still to be exported,
+// the results of completed resources are carried into the report
+func ResumeExport(workDir string) (ExportOptions, []ResourceInfo, error) {
+	journal, err := LoadJournal(workDir)
+	if err != nil {
+		return ExportOptions{}, nil, err
+	}
+
+	results = append(results, journal.Completed()...)
+	partialMARCFiles = journal.MARCFiles
+	options := journal.Options
+	options.Resume = true
+	return options, journal.Remaining(), nil
+}
+
+func (r ExportResult) resourceInfo() ResourceInfo {
+	return ResourceInfo{RepoID: r.RepoID, RepoSlug: r.RepoSlug, ResourceID: r.ResourceID, Type: r.Type}
+}
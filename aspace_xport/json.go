@@ -0,0 +1,310 @@
+package aspace_xport
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/nyudlts/go-aspace"
+)
+
+// a resource with its archival object tree, the archival objects in tree order and the top containers they are in
+type resourceDocument struct {
+	Resource        interface{}   `json:"resource"`
+	Tree            interface{}   `json:"tree"`
+	ArchivalObjects []interface{} `json:"archival_objects"`
+	TopContainers   []interface{} `json:"top_containers"`
+}
+
+// a node of a resource tree returned by the tree endpoints, only the fields needed to walk the tree
+type treeNode struct {
+	URI        string `json:"uri"`
+	ChildCount int    `json:"child_count"`
+	Waypoints  int    `json:"waypoints"`
+}
+
+// the number of records requested at once with an id set
+const recordBatchSize = 100
+
+var archivalObjectURIPattern = regexp.MustCompile(`^/repositories/\d+/archival_objects/(\d+)$`)
+var topContainerURIPattern = regexp.MustCompile(`^/repositories/\d+/top_containers/(\d+)$`)
+
+// export the json of a resource record, with its tree, archival objects and top containers if set in the export options
+func exportJSON(ctx context.Context, info ResourceInfo, res aspace.Resource, recordBytes []byte, workerID int) FormatResult {
+
+	//the record is written without any linked records resolved for other formats so it is the same for every run
+	record, err := decodeJSON(recordBytes)
+	if err != nil {
+		PrintAndLog(fmt.Sprintf("[worker %d] could not read the json of %s: %s", workerID, res.URI, err.Error()), ERROR)
+		return FormatResult{Status: "ERROR", Error: err.Error()}
+	}
+	document := removeResolved(record)
+
+	attempts := 0
+	if exportOptions.IncludeTree == true {
+		var resourceDoc resourceDocument
+		resourceDoc, attempts, err = getResourceDocument(ctx, info, document)
+		document = resourceDoc
+		if err != nil {
+			PrintAndLog(fmt.Sprintf("[worker %d] could not retrieve the tree of %s, code: %s, attempts: %d", workerID, res.URI, err.Error(), attempts), ERROR)
+			return FormatResult{Status: "ERROR", Error: err.Error(), Attempts: attempts}
+		}
+	}
+
+	jsonBytes, err := json.MarshalIndent(document, "", exportOptions.Indent)
+	if err != nil {
+		PrintAndLog(fmt.Sprintf("[worker %d] could not create the json of %s: %s", workerID, res.URI, err.Error()), ERROR)
+		return FormatResult{Status: "ERROR", Error: err.Error(), Attempts: attempts}
+	}
+	jsonBytes = append(jsonBytes, '\n')
+
+	//create the output filename
+	baseFilename := getBaseFilename(res, workerID)
+	jsonFilename := fmt.Sprintf("%s.json", baseFilename)
+
+	//set the location to write the json
+	jsonPath := getOutputPath(info, res, JSON, jsonFilename)
+
+	//write the json
+	if err := WriteFileAtomic(jsonPath, jsonBytes, 0777); err != nil {
+		LogOnly(fmt.Sprintf("[worker %d] could not write the json record %s", workerID, res.URI), ERROR)
+		return FormatResult{Status: "ERROR", Error: err.Error(), Attempts: attempts}
+	}
+
+	LogOnly(fmt.Sprintf("[worker %d] exported resource %s - %s", workerID, res.URI, jsonFilename), INFO)
+	return FormatResult{Status: "SUCCESS", Error: "", Attempts: attempts, Path: jsonPath, Size: int64(len(jsonBytes))}
+}
+
+// get a resource with its tree, the archival objects in the tree and the top containers of their instances,
+// returns the most attempts any request needed. The tree is walked a waypoint at a time and the archival objects
+// and top containers are retrieved in batches
+func getResourceDocument(ctx context.Context, info ResourceInfo, record interface{}) (resourceDocument, int, error) {
+	document := resourceDocument{Resource: record, ArchivalObjects: []interface{}{}, TopContainers: []interface{}{}}
+	treeEndpoint := fmt.Sprintf("/repositories/%d/resources/%d/tree", info.RepoID, info.ResourceID)
+
+	rootBytes, attempts, err := getJSON(ctx, treeEndpoint+"/root")
+	if err != nil {
+		return document, attempts, err
+	}
+	rootNode := treeNode{}
+	if err := json.Unmarshal(rootBytes, &rootNode); err != nil {
+		return document, attempts, err
+	}
+	root, err := decodeJSON(rootBytes)
+	if err != nil {
+		return document, attempts, err
+	}
+	rootMap, ok := root.(map[string]interface{})
+	if !ok {
+		return document, attempts, fmt.Errorf("the tree root of %s is not an object", treeEndpoint)
+	}
+
+	//get the children of each node a waypoint at a time, keeping the archival objects in tree order
+	aoURIs := []string{}
+	var getChildren func(node treeNode, parentNode string) ([]interface{}, error)
+	getChildren = func(node treeNode, parentNode string) ([]interface{}, error) {
+		children := []interface{}{}
+		for offset := 0; offset < node.Waypoints; offset++ {
+			if ctx.Err() != nil {
+				return children, fmt.Errorf("export interrupted: %w", ctx.Err())
+			}
+			endpoint := fmt.Sprintf("%s/waypoint?offset=%d", treeEndpoint, offset)
+			if parentNode != "" {
+				endpoint = endpoint + "&parent_node=" + url.QueryEscape(parentNode)
+			}
+			waypointBytes, waypointAttempts, err := getJSON(ctx, endpoint)
+			attempts = max(attempts, waypointAttempts)
+			if err != nil {
+				return children, err
+			}
+			nodes := []treeNode{}
+			if err := json.Unmarshal(waypointBytes, &nodes); err != nil {
+				return children, err
+			}
+			waypoint, err := decodeJSON(waypointBytes)
+			if err != nil {
+				return children, err
+			}
+			waypointNodes, ok := waypoint.([]interface{})
+			if !ok || len(waypointNodes) != len(nodes) {
+				return children, fmt.Errorf("waypoint %d of %s is not a list of nodes", offset, node.URI)
+			}
+
+			for i, child := range nodes {
+				childMap, ok := waypointNodes[i].(map[string]interface{})
+				if !ok {
+					return children, fmt.Errorf("waypoint %d of %s is not a list of nodes", offset, node.URI)
+				}
+				if archivalObjectURIPattern.MatchString(child.URI) {
+					aoURIs = append(aoURIs, child.URI)
+				}
+				childMap["children"] = []interface{}{}
+				if child.ChildCount > 0 {
+					if childMap["children"], err = getChildren(child, child.URI); err != nil {
+						return children, err
+					}
+				}
+				children = append(children, childMap)
+			}
+		}
+		return children, nil
+	}
+	children, err := getChildren(rootNode, "")
+	if err != nil {
+		return document, attempts, err
+	}
+	delete(rootMap, "precomputed_waypoints")
+	rootMap["children"] = children
+	document.Tree = rootMap
+
+	//get the archival objects and the top containers of their instances in the order they are first linked
+	archivalObjects, aoAttempts, err := getRecords(ctx, info.RepoID, "archival_objects", archivalObjectURIPattern, aoURIs)
+	attempts = max(attempts, aoAttempts)
+	if err != nil {
+		return document, attempts, err
+	}
+	tcURIs := []string{}
+	for _, uri := range aoURIs {
+		archivalObject, err := decodeJSON(archivalObjects[uri])
+		if err != nil {
+			return document, attempts, err
+		}
+		document.ArchivalObjects = append(document.ArchivalObjects, archivalObject)
+		for _, ref := range getTopContainerRefs(archivalObjects[uri]) {
+			if !containsString(tcURIs, ref) {
+				tcURIs = append(tcURIs, ref)
+			}
+		}
+	}
+
+	topContainers, tcAttempts, err := getRecords(ctx, info.RepoID, "top_containers", topContainerURIPattern, tcURIs)
+	attempts = max(attempts, tcAttempts)
+	if err != nil {
+		return document, attempts, err
+	}
+	for _, uri := range tcURIs {
+		topContainer, err := decodeJSON(topContainers[uri])
+		if err != nil {
+			return document, attempts, err
+		}
+		document.TopContainers = append(document.TopContainers, topContainer)
+	}
+
+	return document, attempts, nil
+}
+
+// get the records of a repository by uri in batches with an id set, returns the json of each record by uri and the
+// most attempts any request needed. It is an error if a record is not returned
+func getRecords(ctx context.Context, repositoryID int, recordType string, uriPattern *regexp.Regexp, uris []string) (map[string][]byte, int, error) {
+	records := map[string][]byte{}
+	attempts := 0
+	for start := 0; start < len(uris); start += recordBatchSize {
+		if ctx.Err() != nil {
+			return records, attempts, fmt.Errorf("export interrupted: %w", ctx.Err())
+		}
+		idSet := []string{}
+		for _, uri := range uris[start:min(start+recordBatchSize, len(uris))] {
+			m := uriPattern.FindStringSubmatch(uri)
+			if m == nil {
+				return records, attempts, fmt.Errorf("%s is not a %s uri", uri, recordType)
+			}
+			idSet = append(idSet, "id_set[]="+m[1])
+		}
+		batchBytes, batchAttempts, err := getJSON(ctx, fmt.Sprintf("/repositories/%d/%s?%s", repositoryID, recordType, strings.Join(idSet, "&")))
+		attempts = max(attempts, batchAttempts)
+		if err != nil {
+			return records, attempts, err
+		}
+		batch := []json.RawMessage{}
+		if err := json.Unmarshal(batchBytes, &batch); err != nil {
+			return records, attempts, err
+		}
+		for _, recordBytes := range batch {
+			record := struct {
+				URI string `json:"uri"`
+			}{}
+			if err := json.Unmarshal(recordBytes, &record); err != nil {
+				return records, attempts, err
+			}
+			records[record.URI] = recordBytes
+		}
+	}
+
+	for _, uri := range uris {
+		if _, ok := records[uri]; !ok {
+			return records, attempts, fmt.Errorf("%s was not returned by archivesspace", uri)
+		}
+	}
+	return records, attempts, nil
+}
+
+// get the refs of the top containers of the instances of an archival object
+func getTopContainerRefs(aoBytes []byte) []string {
+	archivalObject := struct {
+		Instances []struct {
+			SubContainer struct {
+				TopContainer struct {
+					Ref string `json:"ref"`
+				} `json:"top_container"`
+			} `json:"sub_container"`
+		} `json:"instances"`
+	}{}
+	refs := []string{}
+	if err := json.Unmarshal(aoBytes, &archivalObject); err != nil {
+		return refs
+	}
+	for _, instance := range archivalObject.Instances {
+		if ref := instance.SubContainer.TopContainer.Ref; ref != "" {
+			refs = append(refs, ref)
+		}
+	}
+	return refs
+}
+
+// get the json of an endpoint
+func getJSON(ctx context.Context, endpoint string) ([]byte, int, error) {
+	var jsonBytes []byte
+	attempts, err := withRetry(ctx, endpoint, func() error {
+		response, err := client.GetEndpoint(endpoint)
+		if err != nil {
+			return err
+		}
+		defer response.Body.Close()
+
+		jsonBytes, err = io.ReadAll(response.Body)
+		return err
+	})
+	return jsonBytes, attempts, err
+}
+
+// decode json keeping numbers as they are written
+func decodeJSON(jsonBytes []byte) (interface{}, error) {
+	var value interface{}
+	decoder := json.NewDecoder(bytes.NewReader(jsonBytes))
+	decoder.UseNumber()
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// remove the resolved copies of linked records from a json value
+func removeResolved(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		delete(v, "_resolved")
+		for key, child := range v {
+			v[key] = removeResolved(child)
+		}
+	case []interface{}:
+		for i, child := range v {
+			v[i] = removeResolved(child)
+		}
+	}
+	return value
+}
@@ -0,0 +1,526 @@
+package aspace_xport
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/nyudlts/go-aspace"
+)
+
+const (
+	marcNamespace    = "http://www.loc.gov/MARC21/slim"
+	marcLeaderLength = 24
+	marc008Length    = 40
+)
+
+type marcSubfield struct {
+	Code  string `xml:"code,attr"`
+	Value string `xml:",chardata"`
+}
+
+// a control or data field, control fields only have a value and data fields only have indicators and subfields
+type marcField struct {
+	Tag       string         `xml:"tag,attr"`
+	Ind1      string         `xml:"ind1,attr"`
+	Ind2      string         `xml:"ind2,attr"`
+	Value     string         `xml:",chardata"`
+	Subfields []marcSubfield `xml:"subfield"`
+	Control   bool           `xml:"-"`
+}
+
+type marcRecord struct {
+	Leader string
+	Fields []marcField
+}
+
+// parse the records in a marcxml document, keeping the fields in document order
+func parseMARCXML(marcBytes []byte) ([]marcRecord, error) {
+	records := []marcRecord{}
+	decoder := xml.NewDecoder(bytes.NewReader(marcBytes))
+	var record *marcRecord
+
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "record":
+				records = append(records, marcRecord{})
+				record = &records[len(records)-1]
+			case "leader":
+				if record == nil {
+					continue
+				}
+				if err := decoder.DecodeElement(&record.Leader, &t); err != nil {
+					return nil, err
+				}
+			case "controlfield", "datafield":
+				if record == nil {
+					continue
+				}
+				field := marcField{}
+				if err := decoder.DecodeElement(&field, &t); err != nil {
+					return nil, err
+				}
+				field.Control = t.Name.Local == "controlfield"
+				if !field.Control {
+					field.Value = ""
+				}
+				record.Fields = append(record.Fields, field)
+			}
+		case xml.EndElement:
+			if t.Name.Local == "record" {
+				record = nil
+			}
+		}
+	}
+
+	return records, nil
+}
+
+// get the fields in a record with a tag
+func (r marcRecord) getFields(tag string) []marcField {
+	fields := []marcField{}
+	for _, field := range r.Fields {
+		if field.Tag == tag {
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
+
+// iso 2709 delimiters
+const (
+	iso2709SubfieldDelimiter = 0x1F
+	iso2709FieldTerminator   = 0x1E
+	iso2709RecordTerminator  = 0x1D
+	iso2709MaxRecordLength   = 99999
+	iso2709MaxFieldLength    = 9999
+)
+
+// convert a record to iso 2709 binary marc, utf-8 encoded
+func (r marcRecord) toISO2709() ([]byte, error) {
+	directory := &bytes.Buffer{}
+	data := &bytes.Buffer{}
+	for _, field := range r.Fields {
+		start := data.Len()
+		if field.Control {
+			data.WriteString(field.Value)
+		} else {
+			data.WriteString(marcIndicator(field.Ind1))
+			data.WriteString(marcIndicator(field.Ind2))
+			for _, subfield := range field.Subfields {
+				data.WriteByte(iso2709SubfieldDelimiter)
+				data.WriteString(subfield.Code)
+				data.WriteString(subfield.Value)
+			}
+		}
+		data.WriteByte(iso2709FieldTerminator)
+
+		length := data.Len() - start
+		if length > iso2709MaxFieldLength {
+			return nil, fmt.Errorf("field %s is %d bytes, longer than the iso 2709 maximum of %d", field.Tag, length, iso2709MaxFieldLength)
+		}
+		if len(field.Tag) != 3 {
+			return nil, fmt.Errorf("field tag %q is not 3 characters", field.Tag)
+		}
+		fmt.Fprintf(directory, "%s%04d%05d", field.Tag, length, start)
+	}
+	directory.WriteByte(iso2709FieldTerminator)
+
+	baseAddress := marcLeaderLength + directory.Len()
+	recordLength := baseAddress + data.Len() + 1
+	if recordLength > iso2709MaxRecordLength {
+		return nil, fmt.Errorf("record is %d bytes, longer than the iso 2709 maximum of %d", recordLength, iso2709MaxRecordLength)
+	}
+
+	//the lengths and addresses in the leader are calculated, the character coding is set to unicode
+	leader := []byte(fmt.Sprintf("%-24s", r.Leader))[:marcLeaderLength]
+	copy(leader[0:5], fmt.Sprintf("%05d", recordLength))
+	leader[9] = 'a'
+	leader[10] = '2'
+	leader[11] = '2'
+	copy(leader[12:17], fmt.Sprintf("%05d", baseAddress))
+	copy(leader[20:24], "4500")
+
+	record := &bytes.Buffer{}
+	record.Grow(recordLength)
+	record.Write(leader)
+	record.Write(directory.Bytes())
+	record.Write(data.Bytes())
+	record.WriteByte(iso2709RecordTerminator)
+	return record.Bytes(), nil
+}
+
+func marcIndicator(indicator string) string {
+	if len(indicator) != 1 {
+		return " "
+	}
+	return indicator
+}
+
+type marcXMLControlField struct {
+	Tag   string `xml:"tag,attr"`
+	Value string `xml:",chardata"`
+}
+
+type marcXMLDataField struct {
+	Tag       string         `xml:"tag,attr"`
+	Ind1      string         `xml:"ind1,attr"`
+	Ind2      string         `xml:"ind2,attr"`
+	Subfields []marcSubfield `xml:"subfield"`
+}
+
+type marcXMLRecord struct {
+	XMLName xml.Name `xml:"record"`
+	Leader  string   `xml:"leader"`
+	Fields  []interface{}
+}
+
+// convert a record to a marcxml record element, in the marc namespace of the collection it is written to
+func (r marcRecord) toMARCXML(prefix string, indent string) ([]byte, error) {
+	record := marcXMLRecord{Leader: r.Leader}
+	for _, field := range r.Fields {
+		if field.Control {
+			record.Fields = append(record.Fields, marcXMLControlField{Tag: field.Tag, Value: field.Value})
+		} else {
+			record.Fields = append(record.Fields, marcXMLDataField{Tag: field.Tag, Ind1: marcIndicator(field.Ind1), Ind2: marcIndicator(field.Ind2), Subfields: field.Subfields})
+		}
+	}
+	return xml.MarshalIndent(record, prefix, indent)
+}
+
+func (f marcXMLControlField) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	type controlfield marcXMLControlField
+	start.Name.Local = "controlfield"
+	return e.EncodeElement(controlfield(f), start)
+}
+
+func (f marcXMLDataField) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	type datafield marcXMLDataField
+	start.Name.Local = "datafield"
+	return e.EncodeElement(datafield(f), start)
+}
+
+// marc outputs, individual marcxml files, a binary marc file for each repository and a marcxml collection for each repository
+const (
+	MARCXMLOutput        = "xml"
+	MARCBinaryOutput     = "mrc"
+	MARCCollectionOutput = "collection"
+)
+
+var marcOutputs = []string{MARCXMLOutput, MARCBinaryOutput, MARCCollectionOutput}
+
+// parse a comma separated list of marc outputs, individual marcxml files are written if no output is set
+func ParseMARCOutputs(outputs string) ([]string, error) {
+	parsed := []string{}
+	for _, output := range strings.Split(outputs, ",") {
+		output = strings.TrimSpace(output)
+		if output == "" || containsString(parsed, output) {
+			continue
+		}
+		if !containsString(marcOutputs, output) {
+			return parsed, fmt.Errorf("unsupported marc output %s, supported outputs are `%s`", output, strings.Join(marcOutputs, "`, `"))
+		}
+		parsed = append(parsed, output)
+	}
+	if len(parsed) == 0 {
+		parsed = append(parsed, MARCXMLOutput)
+	}
+	return parsed, nil
+}
+
+// a file that the marc records of a repository are appended to, the records are written to a temporary file
+// that is renamed when the export is finished
+type marcFile struct {
+	path      string
+	tmpPath   string
+	output    string
+	file      *os.File
+	footer    []byte
+	records   int
+	resources []string
+	err       error
+}
+
+// the number of records written to a repository marc file
+type marcFileSummary struct {
+	Path       string `json:"path"`
+	Records    int    `json:"records"`
+	Incomplete bool   `json:"incomplete,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// a repository marc file left incomplete by an interrupted export, recorded in the journal so a resumed export
+// can append to it
+type partialMARCFile struct {
+	Path        string   `json:"path"`
+	PartialPath string   `json:"partial_path"`
+	Output      string   `json:"output"`
+	Records     int      `json:"records"`
+	Resources   []string `json:"resources"`
+}
+
+const partialExtension = ".partial"
+
+// the repository marc files are shared by every worker
+var (
+	marcFiles         = map[string]*marcFile{}
+	marcFilesMutex    sync.Mutex
+	marcFileSummaries = []marcFileSummary{}
+	partialMARCFiles  = []partialMARCFile{}
+)
+
+// get the path of a repository marc file
+func getMARCFilePath(info ResourceInfo, res aspace.Resource, output string) string {
+	var filename string
+	switch output {
+	case MARCBinaryOutput:
+		filename = strings.ToLower(fmt.Sprintf("%s_%s.mrc", info.RepoSlug, exportOptions.MARCFileTimestamp))
+	default:
+		filename = strings.ToLower(fmt.Sprintf("%s_collection_%s.xml", info.RepoSlug, exportOptions.MARCFileTimestamp))
+	}
+	return getOutputPath(info, res, MARC, filename)
+}
+
+// convert the records of a marcxml document for a repository marc file
+func convertMARCRecords(records []marcRecord, output string) ([]byte, error) {
+	converted := &bytes.Buffer{}
+	for _, record := range records {
+		var recordBytes []byte
+		var err error
+		if output == MARCBinaryOutput {
+			recordBytes, err = record.toISO2709()
+		} else {
+			recordBytes, err = record.toMARCXML(exportOptions.Indent, exportOptions.Indent)
+			recordBytes = append(recordBytes, '\n')
+		}
+		if err != nil {
+			return nil, err
+		}
+		converted.Write(recordBytes)
+	}
+	return converted.Bytes(), nil
+}
+
+// append the records of a resource to a repository marc file, opening the file the first time it is written to.
+// The records of a resource are only appended once, a resource exported again by a resumed export is already in the file
+func appendMARCRecords(path string, output string, uri string, records []marcRecord) (int64, error) {
+	recordBytes, err := convertMARCRecords(records, output)
+	if err != nil {
+		return 0, err
+	}
+
+	marcFilesMutex.Lock()
+	defer marcFilesMutex.Unlock()
+
+	mFile, ok := marcFiles[path]
+	if !ok {
+		mFile = &marcFile{path: path, output: output}
+		marcFiles[path] = mFile
+		mFile.file, mFile.tmpPath, mFile.err = createTempFile(path, 0777)
+		if mFile.err == nil && output == MARCCollectionOutput {
+			_, mFile.err = mFile.file.WriteString(fmt.Sprintf("%s<collection xmlns=\"%s\">\n", xml.Header, marcNamespace))
+		}
+		mFile.setFooter()
+	}
+
+	//once a write has failed the file is incomplete and no more records are written to it
+	if mFile.err != nil {
+		return 0, fmt.Errorf("could not write to %s: %s", path, mFile.err.Error())
+	}
+	if containsString(mFile.resources, uri) {
+		return 0, nil
+	}
+	if _, err := mFile.file.Write(recordBytes); err != nil {
+		mFile.err = err
+		return 0, err
+	}
+	mFile.records = mFile.records + len(records)
+	mFile.resources = append(mFile.resources, uri)
+	return int64(len(recordBytes)), nil
+}
+
+// reopen the repository marc files left incomplete by an interrupted export so a resumed export appends to them,
+// a file that can not be reopened is started again
+func reopenMARCFiles(partials []partialMARCFile) {
+	marcFilesMutex.Lock()
+	defer marcFilesMutex.Unlock()
+
+	for _, partial := range partials {
+		file, err := os.OpenFile(partial.PartialPath, os.O_WRONLY|os.O_APPEND, 0)
+		if err != nil {
+			PrintAndLog(fmt.Sprintf("could not reopen %s, the %d records written before the export was interrupted will not be in %s: %s", partial.PartialPath, partial.Records, partial.Path, err.Error()), WARNING)
+			continue
+		}
+		mFile := &marcFile{path: partial.Path, tmpPath: partial.PartialPath, output: partial.Output, file: file, records: partial.Records, resources: partial.Resources}
+		mFile.setFooter()
+		marcFiles[partial.Path] = mFile
+		LogOnly(fmt.Sprintf("appending to %s, %d records were written before the export was interrupted", partial.PartialPath, partial.Records), INFO)
+	}
+}
+
+// finish the repository marc files, called once every worker has finished. If the export was interrupted the
+// files are left incomplete with a .partial extension and recorded in the journal, and finished by a resumed export
+func closeMARCFiles(journal io.Writer, interrupted bool) error {
+	marcFilesMutex.Lock()
+	defer marcFilesMutex.Unlock()
+
+	paths := []string{}
+	for path := range marcFiles {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	var closeErr error
+	for _, path := range paths {
+		mFile := marcFiles[path]
+		if mFile.err == nil && interrupted {
+			mFile.err = mFile.suspend()
+			if mFile.err == nil {
+				PrintAndLog(fmt.Sprintf("%s is incomplete, resume the export to finish it", mFile.tmpPath), WARNING)
+				marcFileSummaries = append(marcFileSummaries, marcFileSummary{Path: mFile.tmpPath, Records: mFile.records, Incomplete: true})
+				partial := partialMARCFile{Path: mFile.path, PartialPath: mFile.tmpPath, Output: mFile.output, Records: mFile.records, Resources: mFile.resources}
+				if err := writeJournalEntry(journal, journalEntry{Event: journalMARCPartial, MARCFile: &partial}); err != nil {
+					LogOnly(fmt.Sprintf("could not write %s to journal: %s", mFile.tmpPath, err.Error()), WARNING)
+				}
+				continue
+			}
+		}
+		if mFile.err == nil {
+			mFile.err = mFile.finish()
+		}
+		if mFile.err != nil {
+			if mFile.file != nil {
+				mFile.file.Close()
+				os.Remove(mFile.tmpPath)
+			}
+			PrintAndLog(fmt.Sprintf("could not write %s: %s", path, mFile.err.Error()), ERROR)
+			marcFileSummaries = append(marcFileSummaries, marcFileSummary{Path: path, Records: 0, Error: mFile.err.Error()})
+			closeErr = fmt.Errorf("could not write every repository marc file")
+			continue
+		}
+		LogOnly(fmt.Sprintf("wrote %d records to %s", mFile.records, path), INFO)
+		marcFileSummaries = append(marcFileSummaries, marcFileSummary{Path: path, Records: mFile.records})
+	}
+	marcFiles = map[string]*marcFile{}
+	return closeErr
+}
+
+// the closing tag of a collection file is written when the file is finished
+func (m *marcFile) setFooter() {
+	if m.output == MARCCollectionOutput {
+		m.footer = []byte("</collection>\n")
+	}
+}
+
+// close an incomplete file, renaming it to its path with the .partial extension
+func (m *marcFile) suspend() error {
+	if err := m.file.Sync(); err != nil {
+		return err
+	}
+	if err := m.file.Close(); err != nil {
+		return err
+	}
+	m.file = nil
+	partialPath := m.path + partialExtension
+	if m.tmpPath != partialPath {
+		if err := os.Rename(m.tmpPath, partialPath); err != nil {
+			return err
+		}
+		m.tmpPath = partialPath
+	}
+	syncDirectory(filepath.Dir(m.path))
+	return nil
+}
+
+func (m *marcFile) finish() error {
+	if _, err := m.file.Write(m.footer); err != nil {
+		return err
+	}
+	if err := m.file.Sync(); err != nil {
+		return err
+	}
+	if err := m.file.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(m.tmpPath, m.path); err != nil {
+		return err
+	}
+	m.file = nil
+	syncDirectory(filepath.Dir(m.path))
+	return nil
+}
+
+// a marc-in-json record, each field is an object with the tag as its only key so the field order is kept
+type marcJSONRecord struct {
+	Leader string                   `json:"leader"`
+	Fields []map[string]interface{} `json:"fields"`
+}
+
+type marcJSONDataField struct {
+	Subfields []map[string]string `json:"subfields"`
+	Ind1      string              `json:"ind1"`
+	Ind2      string              `json:"ind2"`
+}
+
+// convert a record to marc-in-json
+func (r marcRecord) toMARCJSON() marcJSONRecord {
+	record := marcJSONRecord{Leader: r.Leader, Fields: []map[string]interface{}{}}
+	for _, field := range r.Fields {
+		if field.Control {
+			record.Fields = append(record.Fields, map[string]interface{}{field.Tag: field.Value})
+			continue
+		}
+		dataField := marcJSONDataField{Subfields: []map[string]string{}, Ind1: marcIndicator(field.Ind1), Ind2: marcIndicator(field.Ind2)}
+		for _, subfield := range field.Subfields {
+			dataField.Subfields = append(dataField.Subfields, map[string]string{subfield.Code: subfield.Value})
+		}
+		record.Fields = append(record.Fields, map[string]interface{}{field.Tag: dataField})
+	}
+	return record
+}
+
+// convert a marcxml document to marc-in-json, a document with one record is written as a single object
+// and a document with more than one record as an array
+func marcXMLToJSON(marcBytes []byte, indent string) ([]byte, error) {
+	records, err := parseMARCXML(marcBytes)
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("no marc records")
+	}
+
+	var document interface{}
+	if len(records) == 1 {
+		document = records[0].toMARCJSON()
+	} else {
+		jsonRecords := []marcJSONRecord{}
+		for _, record := range records {
+			jsonRecords = append(jsonRecords, record.toMARCJSON())
+		}
+		document = jsonRecords
+	}
+
+	jsonBytes, err := json.MarshalIndent(document, "", indent)
+	if err != nil {
+		return nil, err
+	}
+	return append(jsonBytes, '\n'), nil
+}
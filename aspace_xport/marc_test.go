@@ -0,0 +1,239 @@
+package aspace_xport
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func testMARCRecord(fields ...marcField) marcRecord {
+	return marcRecord{Leader: "00000npcaa2200000 u 4500", Fields: fields}
+}
+
+func controlField(tag string, value string) marcField {
+	return marcField{Tag: tag, Value: value, Control: true}
+}
+
+func dataField(tag string, ind1 string, ind2 string, subfields ...string) marcField {
+	field := marcField{Tag: tag, Ind1: ind1, Ind2: ind2}
+	for i := 0; i+1 < len(subfields); i += 2 {
+		field.Subfields = append(field.Subfields, marcSubfield{Code: subfields[i], Value: subfields[i+1]})
+	}
+	return field
+}
+
+// a directory entry of an iso 2709 record
+type directoryEntry struct {
+	tag    string
+	length int
+	start  int
+}
+
+// read the leader and directory of an iso 2709 record, checking the lengths and addresses are consistent
+func readISO2709(t *testing.T, record []byte) (int, int, []directoryEntry) {
+	t.Helper()
+	if len(record) < marcLeaderLength {
+		t.Fatalf("record is %d bytes, shorter than the leader", len(record))
+	}
+	recordLength, err := strconv.Atoi(string(record[0:5]))
+	if err != nil {
+		t.Fatalf("record length %q is not a number", record[0:5])
+	}
+	baseAddress, err := strconv.Atoi(string(record[12:17]))
+	if err != nil {
+		t.Fatalf("base address %q is not a number", record[12:17])
+	}
+	if recordLength != len(record) {
+		t.Errorf("leader record length is %d, the record is %d bytes", recordLength, len(record))
+	}
+	if record[len(record)-1] != iso2709RecordTerminator {
+		t.Errorf("record does not end with a record terminator")
+	}
+	if record[baseAddress-1] != iso2709FieldTerminator {
+		t.Errorf("directory does not end with a field terminator at the base address")
+	}
+	if (baseAddress-marcLeaderLength-1)%12 != 0 {
+		t.Fatalf("directory is %d bytes, not a multiple of 12", baseAddress-marcLeaderLength-1)
+	}
+
+	entries := []directoryEntry{}
+	for i := marcLeaderLength; i < baseAddress-1; i += 12 {
+		length, _ := strconv.Atoi(string(record[i+3 : i+7]))
+		start, _ := strconv.Atoi(string(record[i+7 : i+12]))
+		entries = append(entries, directoryEntry{tag: string(record[i : i+3]), length: length, start: start})
+	}
+	return recordLength, baseAddress, entries
+}
+
+func TestToISO2709(t *testing.T) {
+	tests := []struct {
+		name   string
+		record marcRecord
+	}{
+		{"ascii", testMARCRecord(controlField("001", "mss_001"), controlField("008", "000000i19001950xx                  eng d"), dataField("245", "1", "0", "a", "Papers,", "f", "1900-1950."))},
+		{"multi-byte utf-8", testMARCRecord(controlField("001", "mss_001"), dataField("245", "1", "0", "a", "Café papers —", "b", "東京 ☃ 🎉"), dataField("520", " ", " ", "a", "Naïve résumé"))},
+		{"no fields", testMARCRecord()},
+		{"blank indicators", testMARCRecord(dataField("650", "", "", "a", "Art"))},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			record, err := test.record.toISO2709()
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, baseAddress, entries := readISO2709(t, record)
+
+			if want := marcLeaderLength + 12*len(test.record.Fields) + 1; baseAddress != want {
+				t.Errorf("base address is %d, expected %d", baseAddress, want)
+			}
+			if string(record[9:12]) != "a22" || string(record[20:24]) != "4500" {
+				t.Errorf("leader %q does not set the character coding, indicator and subfield code counts and entry map", record[:marcLeaderLength])
+			}
+			if len(entries) != len(test.record.Fields) {
+				t.Fatalf("directory has %d entries, expected %d", len(entries), len(test.record.Fields))
+			}
+
+			//the directory lengths and starting positions count bytes, not characters
+			next := 0
+			for i, entry := range entries {
+				field := test.record.Fields[i]
+				if entry.tag != field.Tag {
+					t.Errorf("entry %d has tag %s, expected %s", i, entry.tag, field.Tag)
+				}
+				if entry.start != next {
+					t.Errorf("field %s starts at %d, expected %d", field.Tag, entry.start, next)
+				}
+				data := record[baseAddress+entry.start : baseAddress+entry.start+entry.length]
+				if data[len(data)-1] != iso2709FieldTerminator {
+					t.Errorf("field %s does not end with a field terminator", field.Tag)
+				}
+				if !utf8.Valid(data) {
+					t.Errorf("field %s is not valid utf-8", field.Tag)
+				}
+
+				var want string
+				if field.Control {
+					want = field.Value
+				} else {
+					want = marcIndicator(field.Ind1) + marcIndicator(field.Ind2)
+					for _, subfield := range field.Subfields {
+						want = want + string(rune(iso2709SubfieldDelimiter)) + subfield.Code + subfield.Value
+					}
+				}
+				if got := string(data[:len(data)-1]); got != want {
+					t.Errorf("field %s is %q, expected %q", field.Tag, got, want)
+				}
+				next = entry.start + entry.length
+			}
+			if baseAddress+next+1 != len(record) {
+				t.Errorf("fields end at %d, the record is %d bytes", baseAddress+next+1, len(record))
+			}
+		})
+	}
+}
+
+func TestToISO2709Errors(t *testing.T) {
+	//a field of multi-byte characters that is under the limit in characters but over it in bytes
+	longField := dataField("520", " ", " ", "a", strings.Repeat("é", 5000))
+	largeRecord := testMARCRecord()
+	for i := 0; i < 11; i++ {
+		largeRecord.Fields = append(largeRecord.Fields, dataField("500", " ", " ", "a", strings.Repeat("x", 9900)))
+	}
+
+	tests := []struct {
+		name   string
+		record marcRecord
+		err    string
+	}{
+		{"field too long", testMARCRecord(longField), "longer than the iso 2709 maximum of 9999"},
+		{"record too long", largeRecord, "longer than the iso 2709 maximum of 99999"},
+		{"short tag", testMARCRecord(dataField("24", "1", "0", "a", "Papers")), "is not 3 characters"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := test.record.toISO2709()
+			if err == nil {
+				t.Fatalf("expected an error containing %q", test.err)
+			}
+			if !strings.Contains(err.Error(), test.err) {
+				t.Errorf("expected an error containing %q, got %s", test.err, err.Error())
+			}
+		})
+	}
+}
+
+func TestToMARCXML(t *testing.T) {
+	tests := []struct {
+		name   string
+		record marcRecord
+	}{
+		{"control and data fields", testMARCRecord(controlField("001", "mss_001"), dataField("245", "1", "0", "a", "Papers,", "f", "1900-1950."))},
+		{"escaped characters", testMARCRecord(dataField("245", "0", "0", "a", `Smith & Jones <"papers">`))},
+		{"multi-byte utf-8", testMARCRecord(dataField("245", "1", "0", "a", "Café papers — 東京"))},
+		{"blank indicators", testMARCRecord(dataField("650", "", "", "a", "Art"))},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			recordBytes, err := test.record.toMARCXML("", "  ")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.HasPrefix(recordBytes, []byte("<record>")) {
+				t.Errorf("record does not start with a record element: %s", recordBytes)
+			}
+
+			//the record is read back in the marc namespace of the collection it is written to
+			collection := []byte(`<collection xmlns="` + marcNamespace + `">` + string(recordBytes) + `</collection>`)
+			records, err := parseMARCXML(collection)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(records) != 1 {
+				t.Fatalf("expected 1 record, got %d", len(records))
+			}
+			record := records[0]
+			if record.Leader != test.record.Leader {
+				t.Errorf("leader is %q, expected %q", record.Leader, test.record.Leader)
+			}
+			if len(record.Fields) != len(test.record.Fields) {
+				t.Fatalf("record has %d fields, expected %d", len(record.Fields), len(test.record.Fields))
+			}
+			for i, field := range record.Fields {
+				want := test.record.Fields[i]
+				if field.Tag != want.Tag || field.Control != want.Control {
+					t.Errorf("field %d is %s, expected %s", i, field.Tag, want.Tag)
+				}
+				if field.Control {
+					if field.Value != want.Value {
+						t.Errorf("controlfield %s is %q, expected %q", field.Tag, field.Value, want.Value)
+					}
+					continue
+				}
+				if field.Ind1 != marcIndicator(want.Ind1) || field.Ind2 != marcIndicator(want.Ind2) {
+					t.Errorf("datafield %s has indicators %q %q, expected %q %q", field.Tag, field.Ind1, field.Ind2, marcIndicator(want.Ind1), marcIndicator(want.Ind2))
+				}
+				if len(field.Subfields) != len(want.Subfields) {
+					t.Fatalf("datafield %s has %d subfields, expected %d", field.Tag, len(field.Subfields), len(want.Subfields))
+				}
+				for j, subfield := range field.Subfields {
+					if subfield != want.Subfields[j] {
+						t.Errorf("datafield %s subfield %d is %v, expected %v", field.Tag, j, subfield, want.Subfields[j])
+					}
+				}
+			}
+
+			validationError := &ValidationError{}
+			if err := validateAgainstSchema(collection, MARCSchema, MARCSchemaInvalid, validationError); err != nil {
+				t.Fatal(err)
+			}
+			if len(validationError.Reasons) > 0 {
+				t.Errorf("record is not valid against the marc21 slim schema: %s", validationError.Error())
+			}
+		})
+	}
+}
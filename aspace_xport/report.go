@@ -0,0 +1,309 @@
+package aspace_xport
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// structured report formats, the text report is always written
+const (
+	JSONReport = "json"
+	CSVReport  = "csv"
+)
+
+var reportFormats = []string{JSONReport, CSVReport}
+
+// a row of a structured report
+type reportRow struct {
+	RepoID     int     `json:"repo_id"`
+	RepoSlug   string  `json:"repo_slug"`
+	Type       string  `json:"type"`
+	ResourceID int     `json:"resource_id"`
+	URI        string  `json:"uri"`
+	EADID      string  `json:"eadid"`
+	Format     string  `json:"format"`
+	Path       string  `json:"path"`
+	Status     string  `json:"status"`
+	Error      string  `json:"error"`
+	Reason     string  `json:"reason"`
+	Size       int64   `json:"size"`
+	Duration   float64 `json:"duration_seconds"`
+	Attempts   int     `json:"attempts"`
+}
+
+// the totals of the results of a repository, or of every repository
+type reportTotals struct {
+	RepoID    int     `json:"repo_id,omitempty"`
+	RepoSlug  string  `json:"repo_slug,omitempty"`
+	Processed int     `json:"processed"`
+	Succeeded int     `json:"succeeded"`
+	Warnings  int     `json:"warnings"`
+	Skipped   int     `json:"skipped"`
+	Errors    int     `json:"errors"`
+	Retried   int     `json:"retried"`
+	Size      int64   `json:"size"`
+	Duration  float64 `json:"duration_seconds"`
+}
+
+type jsonReport struct {
+	StartTime      time.Time         `json:"start_time"`
+	ExecutionTime  float64           `json:"execution_time_seconds"`
+	Formats        []string          `json:"formats"`
+	NotExported    int               `json:"not_exported"`
+	Totals         reportTotals      `json:"totals"`
+	Repositories   []reportTotals    `json:"repositories"`
+	Resources      []reportRow       `json:"resources"`
+	DigitalObjects []reportRow       `json:"digital_objects,omitempty"`
+	Agents         []reportRow       `json:"agents,omitempty"`
+	MARCFiles      []marcFileSummary `json:"marc_files,omitempty"`
+}
+
+var csvReportHeader = []string{"repo_id", "repo_slug", "type", "resource_id", "uri", "eadid", "format", "path", "status", "error", "reason", "size", "duration_seconds", "attempts"}
+var csvSummaryHeader = []string{"repo_id", "repo_slug", "processed", "succeeded", "warnings", "skipped", "errors", "retried", "size", "duration_seconds"}
+
+// parse a comma separated list of structured report formats
+func ParseReportFormats(formats string) ([]string, error) {
+	parsed := []string{}
+	for _, format := range strings.Split(formats, ",") {
+		format = strings.TrimSpace(format)
+		if format == "" {
+			continue
+		}
+		if format != JSONReport && format != CSVReport {
+			return parsed, fmt.Errorf("unsupported report format %s, supported formats are `%s`", format, strings.Join(reportFormats, "`, `"))
+		}
+		parsed = append(parsed, format)
+	}
+	return parsed, nil
+}
+
+// get the rows of a result, a row for each format or a single row if the resource was not exported
+func getReportRows(result ExportResult) []reportRow {
+	row := reportRow{
+		RepoID:     result.RepoID,
+		RepoSlug:   result.RepoSlug,
+		Type:       "resource",
+		ResourceID: result.ResourceID,
+		URI:        result.URI,
+		EADID:      result.EADID,
+		Status:     result.Status,
+		Error:      result.Error,
+		Reason:     result.Reason,
+		Size:       result.Size,
+		Duration:   result.Duration.Seconds(),
+		Attempts:   result.Attempts,
+	}
+	if result.Type != "" {
+		row.Type = result.Type
+	}
+	if len(result.Formats) == 0 {
+		row.Path = result.Path
+		return []reportRow{row}
+	}
+
+	//the duration is of the resource, not of the format
+	rows := []reportRow{}
+	for _, f := range result.Formats {
+		formatRow := row
+		formatRow.Format = f.Format.String()
+		formatRow.Path = f.Path
+		formatRow.Status = f.Status
+		formatRow.Error = f.Error
+		formatRow.Reason = f.Reason
+		formatRow.Size = f.Size
+		if f.Attempts > 0 {
+			formatRow.Attempts = f.Attempts
+		}
+		rows = append(rows, formatRow)
+	}
+	return rows
+}
+
+func (t *reportTotals) add(result ExportResult) {
+	t.Processed++
+	switch result.Status {
+	case "SUCCESS":
+		t.Succeeded++
+	case "WARNING":
+		t.Warnings++
+	case "SKIPPED":
+		t.Skipped++
+	case "ERROR":
+		t.Errors++
+	}
+	if result.Attempts > 1 && result.Status != "ERROR" {
+		t.Retried++
+	}
+	t.Size = t.Size + result.Size
+	t.Duration = t.Duration + result.Duration.Seconds()
+}
+
+// get the totals of all results and of each repository, ordered by repository ID
+func getReportTotals() (reportTotals, []reportTotals) {
+	totals := reportTotals{}
+	repositoryTotals := map[int]*reportTotals{}
+	for _, result := range results {
+		totals.add(result)
+		if _, ok := repositoryTotals[result.RepoID]; !ok {
+			repositoryTotals[result.RepoID] = &reportTotals{RepoID: result.RepoID, RepoSlug: result.RepoSlug}
+		}
+		repositoryTotals[result.RepoID].add(result)
+	}
+
+	repositories := []reportTotals{}
+	for _, t := range repositoryTotals {
+		repositories = append(repositories, *t)
+	}
+	sort.Slice(repositories, func(i, j int) bool { return repositories[i].RepoID < repositories[j].RepoID })
+	return totals, repositories
+}
+
+// get the results ordered by repository, type and ID
+func getSortedResults() []ExportResult {
+	sorted := make([]ExportResult, len(results))
+	copy(sorted, results)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].RepoID != sorted[j].RepoID {
+			return sorted[i].RepoID < sorted[j].RepoID
+		}
+		if sorted[i].Type != sorted[j].Type {
+			return sorted[i].Type < sorted[j].Type
+		}
+		return sorted[i].ResourceID < sorted[j].ResourceID
+	})
+	return sorted
+}
+
+// write the structured reports set in the export options
+func createStructuredReports() error {
+	for _, format := range exportOptions.ReportFormats {
+		var err error
+		switch format {
+		case JSONReport:
+			err = createJSONReport()
+		case CSVReport:
+			err = createCSVReport()
+		}
+		if err != nil {
+			return fmt.Errorf("could not create %s report: %s", format, err.Error())
+		}
+	}
+	return nil
+}
+
+func createJSONReport() error {
+	totals, repositories := getReportTotals()
+	report := jsonReport{
+		StartTime:     startTime,
+		ExecutionTime: executionTime.Seconds(),
+		Formats:       []string{},
+		NotExported:   numRemaining,
+		Totals:        totals,
+		Repositories:  repositories,
+		Resources:     []reportRow{},
+		MARCFiles:     marcFileSummaries,
+	}
+	for _, format := range exportOptions.Formats {
+		report.Formats = append(report.Formats, format.String())
+	}
+	for _, result := range getSortedResults() {
+		switch {
+		case result.Type == DigitalObjectType:
+			report.DigitalObjects = append(report.DigitalObjects, getReportRows(result)...)
+		case isAgentType(result.Type):
+			report.Agents = append(report.Agents, getReportRows(result)...)
+		default:
+			report.Resources = append(report.Resources, getReportRows(result)...)
+		}
+	}
+
+	reportBytes, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	jsonFile := filepath.Join(exportOptions.WorkDir, fmt.Sprintf("aspace-export-report-%s.json", exportOptions.Timestamp))
+	return WriteFileAtomic(jsonFile, append(reportBytes, '\n'), 0644)
+}
+
+// write the results to a csv report and the totals of each repository to a csv summary
+func createCSVReport() error {
+	reportBuffer := &bytes.Buffer{}
+	writer := csv.NewWriter(reportBuffer)
+	if err := writer.Write(csvReportHeader); err != nil {
+		return err
+	}
+	for _, result := range getSortedResults() {
+		for _, row := range getReportRows(result) {
+			if err := writer.Write([]string{
+				strconv.Itoa(row.RepoID),
+				row.RepoSlug,
+				row.Type,
+				strconv.Itoa(row.ResourceID),
+				row.URI,
+				row.EADID,
+				row.Format,
+				row.Path,
+				row.Status,
+				row.Error,
+				row.Reason,
+				strconv.FormatInt(row.Size, 10),
+				strconv.FormatFloat(row.Duration, 'f', 3, 64),
+				strconv.Itoa(row.Attempts),
+			}); err != nil {
+				return err
+			}
+		}
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return err
+	}
+
+	csvFile := filepath.Join(exportOptions.WorkDir, fmt.Sprintf("aspace-export-report-%s.csv", exportOptions.Timestamp))
+	if err := WriteFileAtomic(csvFile, reportBuffer.Bytes(), 0644); err != nil {
+		return err
+	}
+
+	summaryBuffer := &bytes.Buffer{}
+	writer = csv.NewWriter(summaryBuffer)
+	if err := writer.Write(csvSummaryHeader); err != nil {
+		return err
+	}
+	totals, repositories := getReportTotals()
+	totals.RepoSlug = "total"
+	for _, t := range append(repositories, totals) {
+		repoID := strconv.Itoa(t.RepoID)
+		if t.RepoID == 0 && t.RepoSlug != AgentsRepoSlug {
+			repoID = ""
+		}
+		if err := writer.Write([]string{
+			repoID,
+			t.RepoSlug,
+			strconv.Itoa(t.Processed),
+			strconv.Itoa(t.Succeeded),
+			strconv.Itoa(t.Warnings),
+			strconv.Itoa(t.Skipped),
+			strconv.Itoa(t.Errors),
+			strconv.Itoa(t.Retried),
+			strconv.FormatInt(t.Size, 10),
+			strconv.FormatFloat(t.Duration, 'f', 3, 64),
+		}); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return err
+	}
+
+	summaryFile := filepath.Join(exportOptions.WorkDir, fmt.Sprintf("aspace-export-report-summary-%s.csv", exportOptions.Timestamp))
+	return WriteFileAtomic(summaryFile, summaryBuffer.Bytes(), 0644)
+}
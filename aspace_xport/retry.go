@@ -0,0 +1,180 @@
+package aspace_xport
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// classes of error that can be retried
+const (
+	RetryServer    = "server"
+	RetryRateLimit = "rate-limit"
+	RetryTimeout   = "timeout"
+	RetryNetwork   = "network"
+)
+
+const maxRetryDelay = 2 * time.Minute
+
+var retryClasses = []string{RetryServer, RetryRateLimit, RetryTimeout, RetryNetwork}
+
+var retryPolicy = RetryPolicy{MaxAttempts: 1}
+
+// how failed client calls are retried, the delay before each retry doubles from the base delay
+// and is varied by up to the jitter fraction
+type RetryPolicy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	Jitter      float64
+	Retryable   []string
+}
+
+// set the retry policy used for every request to ArchivesSpace
+func SetRetryPolicy(policy RetryPolicy) {
+	retryPolicy = policy
+}
+
+// a panic raised by the client while making a request, go-aspace panics when a request fails without a response
+type clientPanicError struct {
+	value interface{}
+}
+
+func (c clientPanicError) Error() string {
+	return fmt.Sprintf("request failed: %v", c.value)
+}
+
+// parse a comma separated list of retryable error classes
+func ParseRetryable(retryable string) ([]string, error) {
+	classes := []string{}
+	for _, class := range strings.Split(retryable, ",") {
+		class = strings.TrimSpace(class)
+		if class == "" {
+			continue
+		}
+		if !isRetryClass(class) {
+			return classes, fmt.Errorf("unsupported retryable error class %s, supported classes are `%s`", class, strings.Join(retryClasses, "`, `"))
+		}
+		classes = append(classes, class)
+	}
+	return classes, nil
+}
+
+func isRetryClass(class string) bool {
+	for _, c := range retryClasses {
+		if c == class {
+			return true
+		}
+	}
+	return false
+}
+
+// get the class of an error returned by the client, an empty class is never retried
+func classifyError(err error) string {
+	var panicErr clientPanicError
+	if errors.As(err, &panicErr) {
+		return RetryNetwork
+	}
+
+	//go-aspace returns the status code as the error message when the response is not a 200
+	if code, convErr := strconv.Atoi(strings.TrimSpace(err.Error())); convErr == nil {
+		switch {
+		case code == 429:
+			return RetryRateLimit
+		case code == 408:
+			return RetryTimeout
+		case code >= 500 && code <= 599:
+			return RetryServer
+		default:
+			return ""
+		}
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, os.ErrDeadlineExceeded) {
+		return RetryTimeout
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return RetryTimeout
+	}
+
+	var urlErr *url.Error
+	var opErr *net.OpError
+	if errors.As(err, &urlErr) || errors.As(err, &opErr) {
+		return RetryNetwork
+	}
+
+	return ""
+}
+
+func (p RetryPolicy) isRetryable(err error) bool {
+	class := classifyError(err)
+	if class == "" {
+		return false
+	}
+	for _, c := range p.Retryable {
+		if c == class {
+			return true
+		}
+	}
+	return false
+}
+
+// get the delay before a retry, attempt is the number of the attempt that failed
+func (p RetryPolicy) delay(attempt int) time.Duration {
+	delay := p.BaseDelay
+	for i := 1; i < attempt && delay < maxRetryDelay; i++ {
+		delay = delay * 2
+	}
+	if delay > maxRetryDelay {
+		delay = maxRetryDelay
+	}
+
+	if p.Jitter > 0 {
+		delay = time.Duration(float64(delay) * (1 + p.Jitter*(2*rand.Float64()-1)))
+	}
+	return delay
+}
+
+// make a client call, retrying retryable errors according to the retry policy until the context is cancelled,
+// returns the number of attempts made and the error of the last attempt
+func withRetry(ctx context.Context, description string, call func() error) (int, error) {
+	attempt := 1
+	for {
+		err := callClient(call)
+		if err == nil {
+			return attempt, nil
+		}
+
+		if attempt >= retryPolicy.MaxAttempts || !retryPolicy.isRetryable(err) {
+			return attempt, err
+		}
+
+		delay := retryPolicy.delay(attempt)
+		LogOnly(fmt.Sprintf("attempt %d to retrieve %s failed (%s), retrying in %s", attempt, description, err.Error(), delay.Truncate(time.Millisecond).String()), WARNING)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			LogOnly(fmt.Sprintf("export interrupted, not retrying %s", description), WARNING)
+			return attempt, err
+		}
+		attempt++
+	}
+}
+
+// make a client call, converting a panic in the client into an error
+func callClient(call func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = clientPanicError{value: r}
+		}
+	}()
+	return call()
+}
@@ -0,0 +1,312 @@
+package aspace_xport
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var resourceURIPattern = regexp.MustCompile(`/repositories/(\d+)/resources/(\d+)/?$`)
+
+// the column names a resource list may start with
+var resourceListHeaders = []string{"repo_id", "repository_id", "uri", "resource_uri", "eadid", "ead_id"}
+
+// a search result for a resource, only the fields used to select resources
+type resourceHit struct {
+	URI        string `json:"uri"`
+	EADID      string `json:"ead_id"`
+	Identifier string `json:"identifier"`
+	Title      string `json:"title"`
+}
+
+// get a slice of ResourceInfo objects for the resources in a list file, the resources with the identifiers and EADIDs
+// and the resources that match a search query and its filter queries. Each line of a list file is a repository ID and resource ID, a resource URI or an EADID, separated by commas or tabs.
+// Entries that do not match a resource or that match more than one are reported and left out
+func GetSelectedResourceIDs(repMap map[string]int, resourceList string, identifiers []string, eadids []string, query string, filterQueries []string) ([]ResourceInfo, error) {
+	resources := []ResourceInfo{}
+	found := map[string]bool{}
+	add := func(selected []ResourceInfo) {
+		for _, resource := range selected {
+			uri := fmt.Sprintf("/repositories/%d/resources/%d", resource.RepoID, resource.ResourceID)
+			if found[uri] {
+				continue
+			}
+			found[uri] = true
+			resources = append(resources, resource)
+		}
+	}
+
+	unresolved := 0
+	if resourceList != "" {
+		entries, err := readResourceList(resourceList)
+		if err != nil {
+			return resources, err
+		}
+
+		slugs := getRepositorySlugs(repMap)
+		for _, entry := range entries {
+			var selected []ResourceInfo
+			var err error
+			switch {
+			case len(entry.fields) > 1 && isInt(entry.fields[0]) && isInt(entry.fields[1]):
+				repositoryID, _ := strconv.Atoi(entry.fields[0])
+				resourceID, _ := strconv.Atoi(entry.fields[1])
+				selected, err = getListedResource(repositoryID, resourceID, slugs)
+			case resourceURIPattern.MatchString(entry.fields[0]):
+				m := resourceURIPattern.FindStringSubmatch(entry.fields[0])
+				repositoryID, _ := strconv.Atoi(m[1])
+				resourceID, _ := strconv.Atoi(m[2])
+				selected, err = getListedResource(repositoryID, resourceID, slugs)
+			default:
+				selected, err = findResource(repMap, "ead_id", "EADID", entry.fields[0])
+			}
+			if err != nil {
+				PrintAndLog(fmt.Sprintf("%s line %d: %s", resourceList, entry.line, err.Error()), WARNING)
+				unresolved++
+				continue
+			}
+			add(selected)
+		}
+		if unresolved > 0 {
+			PrintAndLog(fmt.Sprintf("%d of %d entries in %s could not be resolved to a resource", unresolved, len(entries), resourceList), WARNING)
+		}
+	}
+
+	//the identifiers and EADIDs set on the command line
+	selectors := []struct {
+		field  string
+		name   string
+		values []string
+	}{{"identifier", "identifier", identifiers}, {"ead_id", "EADID", eadids}}
+	for _, selector := range selectors {
+		for _, value := range selector.values {
+			selected, err := findResource(repMap, selector.field, selector.name, value)
+			if err != nil {
+				PrintAndLog(err.Error(), WARNING)
+				unresolved++
+				continue
+			}
+			add(selected)
+		}
+	}
+
+	//the resources that match the search query in each repository
+	if query != "" {
+		matched, err := findQueryResources(repMap, query, filterQueries)
+		if err != nil {
+			return resources, err
+		}
+		if len(matched) == 0 {
+			unresolved++
+		}
+		add(matched)
+	}
+
+	if len(resources) == 0 {
+		return resources, fmt.Errorf("none of the selected resources could be found, %d selections did not match a single resource", unresolved)
+	}
+	return resources, nil
+}
+
+// find the resources of the repositories that match a search query, printing the number of matches of each repository
+func findQueryResources(repMap map[string]int, query string, filterQueries []string) ([]ResourceInfo, error) {
+	resources := []ResourceInfo{}
+	slugs := getRepositorySlugs(repMap)
+	for _, repositoryID := range getRepositoryIDs(repMap) {
+		hits, err := searchResources(repositoryID, query, filterQueries)
+		if err != nil {
+			return resources, fmt.Errorf("could not search %s: %s", slugs[repositoryID], err.Error())
+		}
+		matched := 0
+		for _, hit := range hits {
+			m := resourceURIPattern.FindStringSubmatch(hit.URI)
+			if m == nil {
+				continue
+			}
+			resourceID, _ := strconv.Atoi(m[2])
+			resources = append(resources, ResourceInfo{RepoID: repositoryID, RepoSlug: slugs[repositoryID], ResourceID: resourceID})
+			matched++
+		}
+		PrintAndLog(fmt.Sprintf("%d resources in %s match the query", matched, slugs[repositoryID]), INFO)
+	}
+	PrintAndLog(fmt.Sprintf("%d resources match the query %s", len(resources), query), INFO)
+	return resources, nil
+}
+
+// find the one resource with an identifier or EADID, it is an error if more than one resource matches
+func findResource(repMap map[string]int, field string, name string, value string) ([]ResourceInfo, error) {
+	resources, err := findResources(repMap, field, value)
+	if err != nil {
+		return resources, err
+	}
+	if len(resources) == 0 {
+		return resources, fmt.Errorf("no resource found with %s %s", name, value)
+	}
+	if len(resources) > 1 {
+		return resources, fmt.Errorf("%s %s is ambiguous, it matches %d resources: %s, use --repository or --resource-list to choose one", name, value, len(resources), getResourceURIs(resources))
+	}
+	return resources, nil
+}
+
+// a line of a resource list
+type resourceListEntry struct {
+	line   int
+	fields []string
+}
+
+// read the entries of a resource list, the fields are tab separated if the file contains a tab and comma separated
+// otherwise, blank lines, lines starting with # and a header line are skipped
+func readResourceList(path string) ([]resourceListEntry, error) {
+	entries := []resourceListEntry{}
+
+	listBytes, err := os.ReadFile(path)
+	if err != nil {
+		return entries, err
+	}
+	listBytes = bytes.TrimPrefix(listBytes, []byte("\ufeff"))
+
+	reader := csv.NewReader(bytes.NewReader(listBytes))
+	if bytes.Contains(listBytes, []byte("\t")) {
+		reader.Comma = '\t'
+	}
+	reader.Comment = '#'
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	reader.LazyQuotes = true
+
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return entries, fmt.Errorf("could not read %s: %s", path, err.Error())
+		}
+		line, _ := reader.FieldPos(0)
+
+		fields := []string{}
+		for _, field := range record {
+			if field = strings.TrimSpace(field); field != "" {
+				fields = append(fields, field)
+			}
+		}
+		if len(fields) == 0 {
+			continue
+		}
+		if len(entries) == 0 && containsString(resourceListHeaders, strings.ToLower(fields[0])) {
+			continue
+		}
+		entries = append(entries, resourceListEntry{line: line, fields: fields})
+	}
+
+	if len(entries) == 0 {
+		return entries, fmt.Errorf("%s does not list any resources", path)
+	}
+	return entries, nil
+}
+
+// get the ResourceInfo of a listed resource, the repository must be one of the repositories being exported
+func getListedResource(repositoryID int, resourceID int, slugs map[int]string) ([]ResourceInfo, error) {
+	slug, ok := slugs[repositoryID]
+	if !ok {
+		return nil, fmt.Errorf("repository %d does not exist or is not being exported", repositoryID)
+	}
+	return []ResourceInfo{{RepoID: repositoryID, RepoSlug: slug, ResourceID: resourceID}}, nil
+}
+
+// find the resources of the repositories whose search index field exactly matches a value
+func findResources(repMap map[string]int, field string, value string) ([]ResourceInfo, error) {
+	resources := []ResourceInfo{}
+	query := fmt.Sprintf("%s:\"%s\"", field, strings.ReplaceAll(value, "\"", "\\\""))
+	slugs := getRepositorySlugs(repMap)
+	for _, repositoryID := range getRepositoryIDs(repMap) {
+		hits, err := searchResources(repositoryID, query, nil)
+		if err != nil {
+			return resources, err
+		}
+		for _, hit := range hits {
+			//the search index matches more loosely than the field value, e.g. by word, so only exact matches are kept
+			var hitValue string
+			switch field {
+			case "ead_id":
+				hitValue = hit.EADID
+			case "identifier":
+				hitValue = hit.Identifier
+			}
+			if hitValue != value {
+				continue
+			}
+			m := resourceURIPattern.FindStringSubmatch(hit.URI)
+			if m == nil {
+				continue
+			}
+			resourceID, _ := strconv.Atoi(m[2])
+			resources = append(resources, ResourceInfo{RepoID: repositoryID, RepoSlug: slugs[repositoryID], ResourceID: resourceID})
+		}
+	}
+	return resources, nil
+}
+
+// get every page of resource hits of a search of a repository, the filter queries are optional
+func searchResources(repositoryID int, query string, filterQueries []string) ([]resourceHit, error) {
+	hits := []resourceHit{}
+	for page, lastPage := 1, 1; page <= lastPage; page++ {
+		endpoint := fmt.Sprintf("/repositories/%d/search?page=%d&page_size=250&type[]=resource&q=%s&fields[]=uri&fields[]=ead_id&fields[]=identifier&fields[]=title", repositoryID, page, url.QueryEscape(query))
+		for _, filterQuery := range filterQueries {
+			endpoint = endpoint + "&filter_query[]=" + url.QueryEscape(filterQuery)
+		}
+		searchPage := struct {
+			LastPage int           `json:"last_page"`
+			Results  []resourceHit `json:"results"`
+		}{}
+		_, err := withRetry(context.Background(), endpoint, func() error {
+			return getEndpointJSON(endpoint, &searchPage)
+		})
+		if err != nil {
+			return hits, err
+		}
+		lastPage = searchPage.LastPage
+		hits = append(hits, searchPage.Results...)
+	}
+	return hits, nil
+}
+
+// get the slug of each repository by ID
+func getRepositorySlugs(repMap map[string]int) map[int]string {
+	slugs := map[int]string{}
+	for slug, repositoryID := range repMap {
+		slugs[repositoryID] = slug
+	}
+	return slugs
+}
+
+// get the IDs of the repositories in order
+func getRepositoryIDs(repMap map[string]int) []int {
+	repositoryIDs := []int{}
+	for _, repositoryID := range repMap {
+		repositoryIDs = append(repositoryIDs, repositoryID)
+	}
+	sort.Ints(repositoryIDs)
+	return repositoryIDs
+}
+
+func getResourceURIs(resources []ResourceInfo) string {
+	uris := []string{}
+	for _, resource := range resources {
+		uris = append(uris, fmt.Sprintf("/repositories/%d/resources/%d", resource.RepoID, resource.ResourceID))
+	}
+	return strings.Join(uris, ", ")
+}
+
+func isInt(s string) bool {
+	_, err := strconv.Atoi(s)
+	return err == nil
+}
@@ -0,0 +1,145 @@
+package aspace_xport
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	StateFilename = "aspace-export-state.json"
+	LastRun       = "last-run"
+)
+
+// the time of the last successful run, keyed by environment and repository ID, with the record type appended to the
+// repository ID for records other than resources, e.g. `2/digital_object`
+type ExportState map[string]map[string]time.Time
+
+var modifiedSinceLayouts = []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02"}
+
+// check that a --modified-since value is either `last-run` or a timestamp
+func ParseModifiedSince(modifiedSince string) (time.Time, error) {
+	if modifiedSince == "" || modifiedSince == LastRun {
+		return time.Time{}, nil
+	}
+
+	for _, layout := range modifiedSinceLayouts {
+		if t, err := time.ParseInLocation(layout, modifiedSince, time.Local); err == nil {
+			return t, nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("could not parse modified since value %s, use `last-run` or a timestamp such as 2006-01-02 or 2006-01-02T15:04:05Z", modifiedSince)
+}
+
+// read the state file from the work directory, returning an empty state if it does not exist
+func ReadExportState(workDir string) (ExportState, error) {
+	state := ExportState{}
+	stateBytes, err := os.ReadFile(filepath.Join(workDir, StateFilename))
+	if errors.Is(err, os.ErrNotExist) {
+		return state, nil
+	} else if err != nil {
+		return state, err
+	}
+
+	if err := json.Unmarshal(stateBytes, &state); err != nil {
+		return state, fmt.Errorf("could not parse state file %s: %s", StateFilename, err.Error())
+	}
+	return state, nil
+}
+
+// get the key of the last run of a record type in a repository, resources are keyed by the repository ID alone
+func stateKey(repositoryID int, recordType string) string {
+	if recordType == "" {
+		return strconv.Itoa(repositoryID)
+	}
+	return fmt.Sprintf("%d/%s", repositoryID, recordType)
+}
+
+// get a description of a record type for messages
+func recordTypeName(recordType string) string {
+	if recordType == "" {
+		return "resources"
+	}
+	return strings.ReplaceAll(recordType, "_", " ") + "s"
+}
+
+// get the time to export modified records of a type from for each repository, repositories without a time are exported
+// in full. The record type is empty for resources
+func GetModifiedSince(modifiedSince string, workDir string, environment string, repositoryMap map[string]int, recordType string) (map[int]time.Time, error) {
+	modifiedSinceMap := map[int]time.Time{}
+	if modifiedSince == "" {
+		return modifiedSinceMap, nil
+	}
+
+	if modifiedSince != LastRun {
+		since, err := ParseModifiedSince(modifiedSince)
+		if err != nil {
+			return modifiedSinceMap, err
+		}
+		for _, repositoryID := range repositoryMap {
+			modifiedSinceMap[repositoryID] = since
+		}
+		return modifiedSinceMap, nil
+	}
+
+	state, err := ReadExportState(workDir)
+	if err != nil {
+		return modifiedSinceMap, err
+	}
+
+	for slug, repositoryID := range repositoryMap {
+		lastRun, ok := state[environment][stateKey(repositoryID, recordType)]
+		if !ok {
+			PrintAndLog(fmt.Sprintf("no previous run recorded for %s in %s, exporting all %s", slug, environment, recordTypeName(recordType)), INFO)
+			continue
+		}
+		PrintAndLog(fmt.Sprintf("exporting %s in %s modified since last run at %s", recordTypeName(recordType), slug, lastRun.Format(time.RFC3339)), INFO)
+		modifiedSinceMap[repositoryID] = lastRun
+	}
+
+	return modifiedSinceMap, nil
+}
+
+// record the start time of this run for each record type in each repository that was exported without errors, the
+// record type is empty for resources
+func UpdateExportState(workDir string, environment string, repositoryMap map[string]int, recordTypes []string, runTime time.Time) error {
+	state, err := ReadExportState(workDir)
+	if err != nil {
+		return err
+	}
+
+	failed := map[string]bool{}
+	for _, result := range results {
+		if result.Status == "ERROR" {
+			failed[stateKey(result.RepoID, result.Type)] = true
+		}
+	}
+
+	if _, ok := state[environment]; !ok {
+		state[environment] = map[string]time.Time{}
+	}
+
+	for _, recordType := range recordTypes {
+		for slug, repositoryID := range repositoryMap {
+			key := stateKey(repositoryID, recordType)
+			if failed[key] {
+				PrintAndLog(fmt.Sprintf("errors were encountered exporting %s in %s, not updating its last run time", recordTypeName(recordType), slug), WARNING)
+				continue
+			}
+			state[environment][key] = runTime
+		}
+	}
+
+	stateBytes, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return WriteFileAtomic(filepath.Join(workDir, StateFilename), stateBytes, 0644)
+}
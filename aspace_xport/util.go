@@ -1,20 +1,57 @@
 package aspace_xport
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"math/rand/v2"
 	"os"
 	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/nyudlts/go-aspace"
 )
 
+// a record to be exported, a resource unless the type is set
 type ResourceInfo struct {
-	RepoID     int
-	RepoSlug   string
-	ResourceID int
+	RepoID     int    `json:"repo_id"`
+	RepoSlug   string `json:"repo_slug"`
+	ResourceID int    `json:"resource_id"`
+	Type       string `json:"type,omitempty"`
 }
 
+// types of record exported other than resources
+const (
+	DigitalObjectType        = "digital_object"
+	AgentPersonType          = "agent_person"
+	AgentCorporateEntityType = "agent_corporate_entity"
+	AgentFamilyType          = "agent_family"
+)
+
+var agentTypes = []string{AgentPersonType, AgentCorporateEntityType, AgentFamilyType}
+
+// agents are not in a repository, they are exported to their own directory and reported under their own repository ID
+// and slug
+const (
+	AgentsRepoID   = 0
+	AgentsRepoSlug = "agents"
+)
+
+// the path of each agent type in the agent and archival context endpoints
+var agentTypePaths = map[string]string{
+	AgentPersonType:          "people",
+	AgentCorporateEntityType: "corporate_entities",
+	AgentFamilyType:          "families",
+}
+
+var agentURIPattern = regexp.MustCompile(`^/agents/(people|corporate_entities|families)/(\d+)$`)
+
 var client *aspace.ASClient
 
 func CreateAspaceClient(config string, environment string, timeout int) error {
@@ -27,7 +64,7 @@ func CreateAspaceClient(config string, environment string, timeout int) error {
 }
 
 // check the application flags
-func CheckFlags(config string, environment string, format string, resource int, repository int) error {
+func CheckFlags(config string, environment string, format string, resource int, resourceList string, identifiers []string, eadids []string, query string, filterQueries []string, repository string, modifiedSince string, exportLocation string, resume string, digitalObjects bool, dmd string, agents bool) error {
 	//check if the config file is set
 	if config == "" {
 		return fmt.Errorf("location of go-aspace config file is mandatory, set the --config option when running aspace-export")
@@ -42,14 +79,71 @@ func CheckFlags(config string, environment string, format string, resource int,
 		return fmt.Errorf("environment to run export against is mandatory, set the --env option when running aspace=export")
 	}
 
-	//check that the format is either `ead` or `marc`
-	if format != "marc" && format != "ead" {
-		return fmt.Errorf("format must be either `ead` or `marc`, set the --format option when running aspace-export")
+	//check that the formats are supported, a resumed export uses the formats in the journal and only digital objects
+	//or agents are exported if a format is not set
+	if _, err := GetExportFormats(format); resume == "" && err != nil && !((digitalObjects || agents) && format == "") {
+		return fmt.Errorf("format must be one or more of `ead`, `ead3`, `marc`, `marc-json`, `mods`, `dc`, `pdf`, `json` or `labels`, set the --format option when running aspace-export")
 	}
 
 	//check that a repository id is set if a resource id is set
-	if resource != 0 && repository == 0 {
-		return fmt.Errorf("a single resource can not be exported if the repository is not specified, set the --repository option when running aspace-export")
+	if resource != 0 && len(ParseRepositories(repository)) != 1 {
+		return fmt.Errorf("a single resource can not be exported if one repository is not specified, set the --repository option to one repository when running aspace-export")
+	}
+
+	//check that a resource list exists
+	if resourceList != "" {
+		if _, err := os.Stat(resourceList); err != nil {
+			return fmt.Errorf("resource list %s does not exist", resourceList)
+		}
+	}
+
+	//check that selected resources are the only selection of resources
+	for _, value := range append(append([]string{}, identifiers...), eadids...) {
+		if strings.TrimSpace(value) == "" {
+			return fmt.Errorf("--identifier and --eadid can not be empty")
+		}
+	}
+	if len(filterQueries) > 0 && strings.TrimSpace(query) == "" {
+		return fmt.Errorf("a filter query can only be used with a search query, set the --query option when running aspace-export")
+	}
+	selected := resourceList != "" || len(identifiers) > 0 || len(eadids) > 0 || query != ""
+	if selected {
+		if resource != 0 {
+			return fmt.Errorf("a single resource can not be exported with selected resources, unset the --resource option when running aspace-export")
+		}
+		if modifiedSince != "" {
+			return fmt.Errorf("every selected resource is exported, unset the --modified-since option when running aspace-export")
+		}
+		if format == "" && resume == "" {
+			return fmt.Errorf("selected resources can not be exported without a format, set the --format option when running aspace-export")
+		}
+	}
+
+	//check the descriptive metadata of exported digital objects
+	if digitalObjects == true {
+		if dmd != "mods" && dmd != "dc" {
+			return fmt.Errorf("digital object descriptive metadata must be `mods` or `dc`, set the --dmd option when running aspace-export")
+		}
+		if resource != 0 || selected {
+			return fmt.Errorf("digital objects can not be exported with a single resource or selected resources, unset the --resource, --resource-list, --identifier, --eadid and --query options when running aspace-export")
+		}
+	}
+
+	//check that the modified since value is `last-run` or a timestamp
+	if _, err := ParseModifiedSince(modifiedSince); err != nil {
+		return err
+	}
+
+	//check that the last run is read from an export location, without one each run writes to a new directory
+	if modifiedSince == LastRun && exportLocation == "" && resume == "" {
+		return fmt.Errorf("the last run is recorded in the export location, set the --export-location option to the location of the previous runs when running aspace-export with --modified-since last-run")
+	}
+
+	//check that the work directory of a resumed export contains a journal
+	if resume != "" {
+		if _, err := os.Stat(filepath.Join(resume, JournalFilename)); err != nil {
+			return fmt.Errorf("can not resume, no journal found in %s", resume)
+		}
 	}
 
 	return nil
@@ -69,35 +163,103 @@ func CheckPath(path string) error {
 }
 
 // get a map of repository slugs and an id --TO DO reverse map order -- index by ID
-func GetRepositoryMap(repository int, environment string) (map[string]int, error) {
-	repositories := make(map[string]int)
+// the repositories are comma separated IDs and slugs, every repository is exported if none are set, and every
+// requested or excluded repository must exist
+func GetRepositoryMap(repositories string, excludeRepositories string, environment string) (map[string]int, error) {
+	repositoryMap := make(map[string]int)
+
+	//get every repository to look up slugs and check that the requested repositories exist
+	var repositoryIds []int
+	_, err := withRetry(context.Background(), "/repositories", func() error {
+		var err error
+		repositoryIds, err = client.GetRepositories()
+		return err
+	})
+	if err != nil {
+		return repositoryMap, err
+	}
 
-	if repository != 0 {
-		repositoryObject, err := client.GetRepository(repository)
+	allRepositories := make(map[string]int)
+	for _, r := range repositoryIds {
+		repositoryObject, err := getRepository(r)
 		if err != nil {
-			return repositories, err
+			return repositoryMap, err
 		}
-		repositories[repositoryObject.Slug] = repository
-	} else {
-		//export all repositories
-		repositoryIds, err := client.GetRepositories()
-		if err != nil {
-			return repositories, err
+		allRepositories[repositoryObject.Slug] = r
+	}
+
+	selected, err := resolveRepositories(ParseRepositories(repositories), allRepositories)
+	if err != nil {
+		return repositoryMap, err
+	}
+	excluded, err := resolveRepositories(ParseRepositories(excludeRepositories), allRepositories)
+	if err != nil {
+		return repositoryMap, err
+	}
+
+	for slug, repositoryID := range allRepositories {
+		if (len(selected) == 0 || selected[slug]) && !excluded[slug] {
+			repositoryMap[slug] = repositoryID
 		}
+	}
+	if len(repositoryMap) == 0 {
+		return repositoryMap, fmt.Errorf("no repositories to export, every selected repository is excluded")
+	}
+	return repositoryMap, nil
+}
 
-		for _, r := range repositoryIds {
-			repositoryObject, err := client.GetRepository(r)
-			if err != nil {
-				return repositories, err
+// parse a comma separated list of repository IDs and slugs, `0` selects every repository
+func ParseRepositories(repositories string) []string {
+	parsed := []string{}
+	for _, repository := range strings.Split(repositories, ",") {
+		repository = strings.TrimSpace(repository)
+		if repository == "" || repository == "0" || containsString(parsed, repository) {
+			continue
+		}
+		parsed = append(parsed, repository)
+	}
+	return parsed
+}
+
+// resolve repository IDs and slugs to the slugs of the repositories, it is an error if any repository does not exist
+func resolveRepositories(repositories []string, allRepositories map[string]int) (map[string]bool, error) {
+	resolved := make(map[string]bool)
+	unknown := []string{}
+	for _, repository := range repositories {
+		found := false
+		for slug, repositoryID := range allRepositories {
+			if repository == strconv.Itoa(repositoryID) || strings.EqualFold(repository, slug) {
+				resolved[slug] = true
+				found = true
 			}
-			repositories[repositoryObject.Slug] = r
 		}
+		if !found {
+			unknown = append(unknown, repository)
+		}
+	}
+
+	if len(unknown) > 0 {
+		available := []string{}
+		for _, repositoryID := range getRepositoryIDs(allRepositories) {
+			available = append(available, fmt.Sprintf("%s (%d)", getRepositorySlugs(allRepositories)[repositoryID], repositoryID))
+		}
+		return resolved, fmt.Errorf("repositories not found: %s, the repositories are %s", strings.Join(unknown, ", "), strings.Join(available, ", "))
 	}
-	return repositories, nil
+	return resolved, nil
+}
+
+func getRepository(repositoryID int) (aspace.Repository, error) {
+	var repositoryObject aspace.Repository
+	_, err := withRetry(context.Background(), fmt.Sprintf("/repositories/%d", repositoryID), func() error {
+		var err error
+		repositoryObject, err = client.GetRepository(repositoryID)
+		return err
+	})
+	return repositoryObject, err
 }
 
-// get a slice of ResourceInfo objects for a repository
-func GetResourceIDs(repMap map[string]int, resource int) ([]ResourceInfo, error) {
+// get a slice of ResourceInfo objects for a repository, limited to resources modified since the time set for the repository
+func GetResourceIDs(repMap map[string]int, resource int, modifiedSince map[int]time.Time) ([]ResourceInfo, error) {
 
 	resources := []ResourceInfo{}
 
@@ -111,7 +273,16 @@ func GetResourceIDs(repMap map[string]int, resource int) ([]ResourceInfo, error)
 			continue
 		}
 
-		resourceIDs, err := client.GetResourceIDs(repositoryID)
+		var resourceIDs []int
+		_, err := withRetry(context.Background(), fmt.Sprintf("/repositories/%d/resources", repositoryID), func() error {
+			var err error
+			if since, ok := modifiedSince[repositoryID]; ok {
+				resourceIDs, err = getModifiedIDs(fmt.Sprintf("/repositories/%d/resources", repositoryID), since)
+			} else {
+				resourceIDs, err = client.GetResourceIDs(repositoryID)
+			}
+			return err
+		})
 		if err != nil {
 			return resources, err
 		}
@@ -128,11 +299,152 @@ func GetResourceIDs(repMap map[string]int, resource int) ([]ResourceInfo, error)
 	return resources, nil
 }
 
+// get a slice of ResourceInfo objects for the digital objects of each repository, limited to digital objects modified
+// since the time set for the repository
+func GetDigitalObjectIDs(repMap map[string]int, modifiedSince map[int]time.Time) ([]ResourceInfo, error) {
+	digitalObjects := []ResourceInfo{}
+
+	for repositorySlug, repositoryID := range repMap {
+		var digitalObjectIDs []int
+		endpoint := fmt.Sprintf("/repositories/%d/digital_objects", repositoryID)
+		_, err := withRetry(context.Background(), endpoint, func() error {
+			var err error
+			if since, ok := modifiedSince[repositoryID]; ok {
+				digitalObjectIDs, err = getModifiedIDs(endpoint, since)
+			} else {
+				digitalObjectIDs, err = client.GetDigitalObjectIDs(repositoryID)
+			}
+			return err
+		})
+		if err != nil {
+			return digitalObjects, err
+		}
+
+		for _, digitalObjectID := range digitalObjectIDs {
+			digitalObjects = append(digitalObjects, ResourceInfo{
+				RepoID:     repositoryID,
+				RepoSlug:   repositorySlug,
+				ResourceID: digitalObjectID,
+				Type:       DigitalObjectType,
+			})
+		}
+	}
+
+	return digitalObjects, nil
+}
+
+// get a slice of ResourceInfo objects for agents, either every agent or only the agents linked to published resources
+// in the repositories. Agents are not in a repository, the repository is only used to export the agent,
+// the agent is reported under AgentsRepoID and AgentsRepoSlug.
+func GetAgentIDs(repMap map[string]int, linked bool) ([]ResourceInfo, error) {
+	agents := []ResourceInfo{}
+
+	//order the repositories by ID so agents are exported from the same repository on every run
+	slugs := []string{}
+	for slug := range repMap {
+		slugs = append(slugs, slug)
+	}
+	sort.Slice(slugs, func(i, j int) bool { return repMap[slugs[i]] < repMap[slugs[j]] })
+	if len(slugs) == 0 {
+		return agents, nil
+	}
+
+	if linked == false {
+		for _, agentType := range agentTypes {
+			endpoint := fmt.Sprintf("/agents/%s?all_ids=true", agentTypePaths[agentType])
+			agentIDs := []int{}
+			_, err := withRetry(context.Background(), endpoint, func() error {
+				return getEndpointJSON(endpoint, &agentIDs)
+			})
+			if err != nil {
+				return agents, err
+			}
+			for _, agentID := range agentIDs {
+				agents = append(agents, ResourceInfo{RepoID: repMap[slugs[0]], RepoSlug: slugs[0], ResourceID: agentID, Type: agentType})
+			}
+		}
+		return agents, nil
+	}
+
+	//find the agents linked to published resources with the search index
+	found := map[string]bool{}
+	for _, slug := range slugs {
+		for page, lastPage := 1, 1; page <= lastPage; page++ {
+			endpoint := fmt.Sprintf("/repositories/%d/search?page=%d&page_size=250&type[]=resource&filter_query[]=publish:true&fields[]=agent_uris", repMap[slug], page)
+			searchPage := struct {
+				LastPage int `json:"last_page"`
+				Results  []struct {
+					AgentURIs []string `json:"agent_uris"`
+				} `json:"results"`
+			}{}
+			_, err := withRetry(context.Background(), endpoint, func() error {
+				return getEndpointJSON(endpoint, &searchPage)
+			})
+			if err != nil {
+				return agents, err
+			}
+			lastPage = searchPage.LastPage
+
+			for _, result := range searchPage.Results {
+				for _, agentURI := range result.AgentURIs {
+					m := agentURIPattern.FindStringSubmatch(agentURI)
+					if m == nil || found[agentURI] {
+						continue
+					}
+					found[agentURI] = true
+					agentID, _ := strconv.Atoi(m[2])
+					for agentType, path := range agentTypePaths {
+						if path == m[1] {
+							agents = append(agents, ResourceInfo{RepoID: repMap[slug], RepoSlug: slug, ResourceID: agentID, Type: agentType})
+						}
+					}
+				}
+			}
+		}
+	}
+	return agents, nil
+}
+
+// get the json of an endpoint
+func getEndpointJSON(endpoint string, v interface{}) error {
+	response, err := client.GetEndpoint(endpoint)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(body, v)
+}
+
+// get the IDs of the records of a listing endpoint whose system_mtime is after a time
+func getModifiedIDs(endpoint string, since time.Time) ([]int, error) {
+	resourceIDs := []int{}
+	response, err := client.GetEndpoint(fmt.Sprintf("%s?all_ids=true&modified_since=%d", endpoint, since.Unix()))
+	if err != nil {
+		return resourceIDs, err
+	}
+	defer response.Body.Close()
+
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		return resourceIDs, err
+	}
+
+	if err := json.Unmarshal(body, &resourceIDs); err != nil {
+		return resourceIDs, err
+	}
+	return resourceIDs, nil
+}
+
 // check that a work directory does not exist if so create it
 func CreateWorkDirectory(workDirPath string) error {
 	//determine if the directory already exists or if there is an error, if so return an error
 	if _, err := os.Stat(workDirPath); err == nil {
-		return fmt.Errorf("work directory %s already exists")
+		return fmt.Errorf("work directory %s already exists", workDirPath)
 	} else if errors.Is(err, os.ErrNotExist) {
 		//the workDir doesn't exist -- create it if there are no other errors
 	} else {
@@ -148,42 +460,38 @@ func CreateWorkDirectory(workDirPath string) error {
 	return nil
 }
 
-// create the repository, export, failure and unpublished sub directories in the work directory
-func CreateExportDirectories(workDirPath string, repositoryMap map[string]int, unpublishedResources bool) error {
+// create the repository, export, failure and unpublished sub directories in the work directory, when more than one
+// format is exported each sub directory has a directory for each format
+func CreateExportDirectories(workDirPath string, repositoryMap map[string]int, formats []ExportFormat, unpublishedResources bool, validate bool) error {
 	for slug := range repositoryMap {
 
 		repositoryDir := filepath.Join(workDirPath, slug)
-		exportDir := filepath.Join(repositoryDir, "exports")
-		unpublishedDir := filepath.Join(repositoryDir, "unpublished")
+		if err := createDirectory(repositoryDir, "repository"); err != nil {
+			return err
+		}
 
-		if _, err := os.Stat(repositoryDir); err != nil {
-			if err := os.Mkdir(repositoryDir, 0755); err != nil {
-				return err
-			}
-			PrintAndLog(fmt.Sprintf("created repository directory %s", repositoryDir), INFO)
-		} else {
-			PrintAndLog(fmt.Sprintf("repository directory %s already exists, skipping", repositoryDir), INFO)
+		subDirectories := []string{"exports"}
+		//create the unpublished directory if needed
+		if unpublishedResources == true {
+			subDirectories = append(subDirectories, "unpublished")
+		}
+		//create the failures directory if needed
+		if validate == true {
+			subDirectories = append(subDirectories, "failures")
 		}
 
-		//create the repository export directory
-		if _, err := os.Stat(exportDir); err != nil {
-			if err := os.Mkdir(exportDir, 0755); err != nil {
+		for _, subDirectory := range subDirectories {
+			dir := filepath.Join(repositoryDir, subDirectory)
+			if err := createDirectory(dir, subDirectory); err != nil {
 				return err
 			}
-			PrintAndLog(fmt.Sprintf("created export directory %s", exportDir), INFO)
-		} else {
-			PrintAndLog(fmt.Sprintf("exports directory %s already exists, skipping", exportDir), INFO)
-		}
-
-		//create the unpublished directory if needed
-		if unpublishedResources == true {
-			if _, err := os.Stat(unpublishedDir); err != nil {
-				if err := os.Mkdir(unpublishedDir, 0755); err != nil {
+			if len(formats) < 2 {
+				continue
+			}
+			for _, format := range formats {
+				if err := createDirectory(filepath.Join(dir, format.String()), fmt.Sprintf("%s %s", format, subDirectory)); err != nil {
 					return err
 				}
-				PrintAndLog(fmt.Sprintf("created unpublished directory %s", unpublishedDir), INFO)
-			} else {
-				PrintAndLog(fmt.Sprintf("unpublished directory %s already exists, skipping", unpublishedDir), INFO)
 			}
 		}
 	}
@@ -191,6 +499,103 @@ func CreateExportDirectories(workDirPath string, repositoryMap map[string]int, u
 	return nil
 }
 
+// create the digital object directory in each repository directory
+func CreateDigitalObjectDirectories(workDirPath string, repositoryMap map[string]int) error {
+	for slug := range repositoryMap {
+		if err := createDirectory(filepath.Join(workDirPath, slug), "repository"); err != nil {
+			return err
+		}
+		if err := createDirectory(filepath.Join(workDirPath, slug, "digital_objects"), "digital object"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// create the agent directory in the work directory with a directory for each agent type
+func CreateAgentDirectories(workDirPath string) error {
+	if err := createDirectory(filepath.Join(workDirPath, AgentsRepoSlug), "agent"); err != nil {
+		return err
+	}
+	for _, agentType := range agentTypes {
+		if err := createDirectory(filepath.Join(workDirPath, AgentsRepoSlug, agentTypePaths[agentType]), "agent"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// create a directory if it does not exist
+func createDirectory(dir string, name string) error {
+	if _, err := os.Stat(dir); err != nil {
+		if err := os.Mkdir(dir, 0755); err != nil {
+			return err
+		}
+		PrintAndLog(fmt.Sprintf("created %s directory %s", name, dir), INFO)
+	} else {
+		PrintAndLog(fmt.Sprintf("%s directory %s already exists, skipping", name, dir), INFO)
+	}
+	return nil
+}
+
+// write a file atomically, the bytes are written to a temporary file in the same directory, synced to disk and then
+// renamed to the path, so a file at the path is always complete
+func WriteFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmpFile, tmpPath, err := createTempFile(path, perm)
+	if err != nil {
+		return err
+	}
+
+	//remove the temporary file if it is not renamed
+	renamed := false
+	defer func() {
+		if !renamed {
+			tmpFile.Close()
+			os.Remove(tmpPath)
+		}
+	}()
+
+	if _, err := tmpFile.Write(data); err != nil {
+		return err
+	}
+	if err := tmpFile.Sync(); err != nil {
+		return err
+	}
+	if err := tmpFile.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return err
+	}
+	renamed = true
+	syncDirectory(filepath.Dir(path))
+
+	return nil
+}
+
+// create a temporary file next to a path with the permissions, less the umask, as os.WriteFile does
+func createTempFile(path string, perm os.FileMode) (*os.File, string, error) {
+	var tmpFile *os.File
+	var tmpPath string
+	var err error
+	for i := 0; i < 10; i++ {
+		tmpPath = filepath.Join(filepath.Dir(path), fmt.Sprintf(".%s.%d.tmp", filepath.Base(path), rand.Uint32()))
+		tmpFile, err = os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+		if !errors.Is(err, os.ErrExist) {
+			break
+		}
+	}
+	return tmpFile, tmpPath, err
+}
+
+// sync a directory so the renames in it are durable
+func syncDirectory(path string) {
+	if dir, err := os.Open(path); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
+}
+
 func MoveLogfile(workDir string) error {
 	newLogLoc := filepath.Join(workDir, Logfile)
 	if err := os.Rename(Logfile, newLogLoc); err != nil {
@@ -0,0 +1,189 @@
+package aspace_xport
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// the bundled schemas, the ead schemas are structural profiles rather than the official schemas
+const (
+	EAD2002Profile = "ead-structure.xsd"
+	EAD3Profile    = "ead3-structure.xsd"
+	MARCSchema     = "MARC21slim.xsd"
+)
+
+// machine-readable validation failure reasons
+const (
+	EADStructureInvalid  = "ead-structure-invalid"
+	EAD3StructureInvalid = "ead3-structure-invalid"
+	MARCSchemaInvalid    = "marc-schema-invalid"
+	MARCNotWellFormed    = "marc-not-well-formed"
+	MARCNoRecords        = "marc-no-records"
+	MARCLeaderLength     = "marc-leader-length"
+	MARCMissing008       = "marc-missing-008"
+	MARCInvalid008       = "marc-invalid-008"
+	MARCMissing245       = "marc-missing-245"
+	MARCFieldOrder       = "marc-field-order"
+	PDFInvalid           = "pdf-invalid"
+	ValidationFailed     = "validation-failed"
+)
+
+// a validation failure with one or more machine-readable reasons
+type ValidationError struct {
+	Reasons  []string
+	Messages []string
+}
+
+func (v *ValidationError) Error() string {
+	return strings.Join(v.Messages, "; ")
+}
+
+// get the reasons as a comma separated list
+func (v *ValidationError) Reason() string {
+	return strings.Join(v.Reasons, ",")
+}
+
+func (v *ValidationError) add(reason string, msg string) {
+	for _, r := range v.Reasons {
+		if r == reason {
+			v.Messages = append(v.Messages, msg)
+			return
+		}
+	}
+	v.Reasons = append(v.Reasons, reason)
+	v.Messages = append(v.Messages, msg)
+}
+
+// get the machine-readable reason for a validation error
+func GetValidationReason(err error) string {
+	var validationError *ValidationError
+	if errors.As(err, &validationError) {
+		return validationError.Reason()
+	}
+	return ValidationFailed
+}
+
+// check the structure of an ead finding aid against the bundled ead 2002 profile, this is not a validation against
+// the official ead 2002 schema
+func ValidateEAD(eadBytes []byte) error {
+	validationError := &ValidationError{}
+	if err := validateAgainstSchema(eadBytes, EAD2002Profile, EADStructureInvalid, validationError); err != nil {
+		return err
+	}
+
+	if len(validationError.Reasons) > 0 {
+		return validationError
+	}
+	return nil
+}
+
+// check the structure of an ead3 finding aid against the bundled ead3 profile, this is not a validation against
+// the official ead3 schema
+func ValidateEAD3(eadBytes []byte) error {
+	validationError := &ValidationError{}
+	if err := validateAgainstSchema(eadBytes, EAD3Profile, EAD3StructureInvalid, validationError); err != nil {
+		return err
+	}
+
+	if len(validationError.Reasons) > 0 {
+		return validationError
+	}
+	return nil
+}
+
+// check that a pdf has a pdf header and end of file marker, archivesspace returns an error page if the pdf
+// could not be generated
+func ValidatePDF(pdfBytes []byte) error {
+	validationError := &ValidationError{}
+	if !bytes.HasPrefix(pdfBytes, []byte("%PDF-")) {
+		validationError.add(PDFInvalid, "pdf does not start with a %PDF- header")
+	}
+	if !bytes.Contains(pdfBytes[max(0, len(pdfBytes)-1024):], []byte("%%EOF")) {
+		validationError.add(PDFInvalid, "pdf does not end with a %%EOF marker")
+	}
+
+	if len(validationError.Reasons) > 0 {
+		return validationError
+	}
+	return nil
+}
+
+// validate a marcxml document against the bundled marc21 slim schema and check the structure of each record
+func ValidateMARC(marcBytes []byte) error {
+	validationError := &ValidationError{}
+	if err := validateAgainstSchema(marcBytes, MARCSchema, MARCSchemaInvalid, validationError); err != nil {
+		return err
+	}
+
+	records, err := parseMARCXML(marcBytes)
+	if err != nil {
+		validationError.add(MARCNotWellFormed, fmt.Sprintf("could not parse marcxml: %s", err.Error()))
+		return validationError
+	}
+
+	if len(records) == 0 {
+		validationError.add(MARCNoRecords, "marcxml does not contain any records")
+	}
+
+	for i, record := range records {
+		checkMARCRecord(i+1, record, validationError)
+	}
+
+	if len(validationError.Reasons) > 0 {
+		return validationError
+	}
+	return nil
+}
+
+func checkMARCRecord(recordNum int, record marcRecord, validationError *ValidationError) {
+	//check the leader length
+	if utf8.RuneCountInString(record.Leader) != marcLeaderLength {
+		validationError.add(MARCLeaderLength, fmt.Sprintf("record %d: leader is %d characters, must be %d", recordNum, utf8.RuneCountInString(record.Leader), marcLeaderLength))
+	}
+
+	//check for the required fields
+	fixedFields := record.getFields("008")
+	if len(fixedFields) == 0 {
+		validationError.add(MARCMissing008, fmt.Sprintf("record %d: missing required 008 field", recordNum))
+	} else if !fixedFields[0].Control || utf8.RuneCountInString(fixedFields[0].Value) != marc008Length {
+		validationError.add(MARCInvalid008, fmt.Sprintf("record %d: 008 field must be a %d character controlfield", recordNum, marc008Length))
+	}
+
+	if len(record.getFields("245")) == 0 {
+		validationError.add(MARCMissing245, fmt.Sprintf("record %d: missing required 245 field", recordNum))
+	}
+
+	//check that the controlfields precede the datafields and are in tag order
+	seenDataField := false
+	previousControlTag := ""
+	for _, field := range record.Fields {
+		if !field.Control {
+			seenDataField = true
+			continue
+		}
+		if seenDataField {
+			validationError.add(MARCFieldOrder, fmt.Sprintf("record %d: controlfield %s follows a datafield", recordNum, field.Tag))
+		}
+		if field.Tag < previousControlTag {
+			validationError.add(MARCFieldOrder, fmt.Sprintf("record %d: controlfield %s follows controlfield %s", recordNum, field.Tag, previousControlTag))
+		}
+		previousControlTag = field.Tag
+	}
+}
+
+// validate a document against a bundled schema, adding any failures to the validation error with the reason
+func validateAgainstSchema(xmlBytes []byte, schemaFile string, reason string, validationError *ValidationError) error {
+	schema, err := getSchema(schemaFile)
+	if err != nil {
+		return err
+	}
+
+	for _, msg := range schema.validate(xmlBytes) {
+		validationError.add(reason, fmt.Sprintf("%s: %s", schemaFile, msg))
+	}
+
+	return nil
+}
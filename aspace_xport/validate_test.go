@@ -0,0 +1,241 @@
+package aspace_xport
+
+import (
+	"strings"
+	"testing"
+)
+
+const testEAD = `<?xml version="1.0" encoding="UTF-8"?>
+<ead xmlns="urn:isbn:1-931666-22-9" xmlns:xlink="http://www.w3.org/1999/xlink">
+  <eadheader findaidstatus="completed" repositoryencoding="iso15511" countryencoding="iso3166-1" dateencoding="iso8601" langencoding="iso639-2b">
+    <eadid countrycode="US" mainagencycode="US-NNU">mss_001</eadid>
+    <filedesc>
+      <titlestmt>
+        <titleproper>Guide to the Papers <num>MSS.001</num></titleproper>
+      </titlestmt>
+    </filedesc>
+  </eadheader>
+  <archdesc level="collection">
+    <did>
+      <unittitle>Papers</unittitle>
+      <unitid>MSS.001</unitid>
+      <unitdate normal="1900/1950" type="inclusive">1900-1950</unitdate>
+    </did>
+    %s
+    <dsc>
+      <c01 id="aspace_1" level="series">
+        <did>
+          <unittitle>Correspondence</unittitle>
+          <container type="box" label="Mixed Materials">1</container>
+        </did>
+        <c02 level="file">
+          <did><unittitle>Letters</unittitle></did>
+        </c02>
+      </c01>
+    </dsc>
+  </archdesc>
+</ead>`
+
+const testEAD3 = `<?xml version="1.0" encoding="UTF-8"?>
+<ead xmlns="http://ead3.archivists.org/schema/">
+  <control>
+    <recordid>mss_001</recordid>
+    <filedesc>
+      <titlestmt><titleproper>Guide to the Papers</titleproper></titlestmt>
+    </filedesc>
+    <maintenancestatus value="derived"/>
+    <maintenanceagency><agencyname>Fales Library</agencyname></maintenanceagency>
+    <maintenancehistory>
+      <maintenanceevent>
+        <eventtype value="derived"/>
+        <eventdatetime>2020-01-01</eventdatetime>
+        <agenttype value="machine"/>
+        <agent>ArchivesSpace</agent>
+      </maintenanceevent>
+    </maintenancehistory>
+  </control>
+  <archdesc level="collection">
+    <did>
+      <unittitle>Papers</unittitle>
+      <unitdatestructured unitdatetype="inclusive"><daterange><fromdate standarddate="1900">1900</fromdate><todate standarddate="1950">1950</todate></daterange></unitdatestructured>
+    </did>
+    %s
+    <dsc>
+      <c level="file"><did><unittitle>Letters</unittitle></did></c>
+    </dsc>
+  </archdesc>
+</ead>`
+
+const testMARC = `<?xml version="1.0" encoding="UTF-8"?>
+<collection xmlns="http://www.loc.gov/MARC21/slim">
+  <record>
+    <leader>%s</leader>
+    %s
+  </record>
+</collection>`
+
+const (
+	testLeader  = "00000npcaa2200000 u 4500"
+	test008     = `<controlfield tag="008">000000i19001950xx                  eng d</controlfield>`
+	test245     = `<datafield tag="245" ind1="1" ind2="0"><subfield code="a">Papers</subfield></datafield>`
+	test001     = `<controlfield tag="001">mss_001</controlfield>`
+	testContent = test008 + test245
+)
+
+func eadWith(desc string) []byte {
+	return []byte(strings.Replace(testEAD, "%s", desc, 1))
+}
+
+func ead3With(desc string) []byte {
+	return []byte(strings.Replace(testEAD3, "%s", desc, 1))
+}
+
+func marcWith(leader string, fields string) []byte {
+	return []byte(strings.Replace(strings.Replace(testMARC, "%s", leader, 1), "%s", fields, 1))
+}
+
+func TestValidateEAD(t *testing.T) {
+	tests := []struct {
+		name   string
+		ead    []byte
+		reason string
+	}{
+		{"valid", eadWith(`<scopecontent id="aspace_2"><head>Scope and Contents</head><p>Letters <emph render="italic">and</emph> diaries.</p></scopecontent>`), ""},
+		{"valid without descriptive elements", eadWith(""), ""},
+		{"valid with xlink attributes", eadWith(`<odd><p><extref xlink:href="https://example.org" xlink:type="simple">link</extref></p></odd>`), ""},
+		{"unknown element in a paragraph", eadWith(`<scopecontent><p>Letters <bogus>and</bogus> diaries.</p></scopecontent>`), EADStructureInvalid},
+		{"unknown attribute", eadWith(`<scopecontent bogus="true"><p>Letters</p></scopecontent>`), EADStructureInvalid},
+		{"head after a paragraph", eadWith(`<scopecontent><p>Letters</p><head>Scope and Contents</head></scopecontent>`), EADStructureInvalid},
+		{"invalid level", []byte(strings.Replace(string(eadWith("")), `level="collection"`, `level="box"`, 1)), EADStructureInvalid},
+		{"missing did", []byte(strings.Replace(string(eadWith("")), "<c02 level=\"file\">\n          <did><unittitle>Letters</unittitle></did>", "<c02 level=\"file\">", 1)), EADStructureInvalid},
+		{"wrong namespace", []byte(strings.Replace(string(eadWith("")), "urn:isbn:1-931666-22-9", "urn:example", 1)), EADStructureInvalid},
+		{"not well-formed", []byte("<ead xmlns=\"urn:isbn:1-931666-22-9\"><eadheader>"), EADStructureInvalid},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := ValidateEAD(test.ead)
+			if test.reason == "" {
+				if err != nil {
+					t.Fatalf("expected the ead to be valid, got %s", err.Error())
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("expected %s, the ead was valid", test.reason)
+			}
+			if reason := GetValidationReason(err); reason != test.reason {
+				t.Errorf("expected reason %s, got %s: %s", test.reason, reason, err.Error())
+			}
+		})
+	}
+}
+
+func TestValidateEAD3(t *testing.T) {
+	tests := []struct {
+		name   string
+		ead    []byte
+		reason string
+	}{
+		{"valid", ead3With(`<scopecontent><head>Scope and Contents</head><p>Letters <emph render="italic">and</emph> <ref href="https://example.org" show="new">diaries</ref>.</p></scopecontent>`), ""},
+		{"unknown element in a paragraph", ead3With(`<scopecontent><p>Letters <bogus>and</bogus> diaries.</p></scopecontent>`), EAD3StructureInvalid},
+		{"unknown attribute", ead3With(`<scopecontent bogus="true"><p>Letters</p></scopecontent>`), EAD3StructureInvalid},
+		{"head after a paragraph", ead3With(`<scopecontent><p>Letters</p><head>Scope and Contents</head></scopecontent>`), EAD3StructureInvalid},
+		{"invalid maintenance status", []byte(strings.Replace(string(ead3With("")), `<maintenancestatus value="derived"/>`, `<maintenancestatus value="done"/>`, 1)), EAD3StructureInvalid},
+		{"missing maintenance agency", []byte(strings.Replace(string(ead3With("")), `<maintenanceagency><agencyname>Fales Library</agencyname></maintenanceagency>`, "", 1)), EAD3StructureInvalid},
+		{"ead 2002", eadWith(""), EAD3StructureInvalid},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := ValidateEAD3(test.ead)
+			if test.reason == "" {
+				if err != nil {
+					t.Fatalf("expected the ead3 to be valid, got %s", err.Error())
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("expected %s, the ead3 was valid", test.reason)
+			}
+			if reason := GetValidationReason(err); reason != test.reason {
+				t.Errorf("expected reason %s, got %s: %s", test.reason, reason, err.Error())
+			}
+		})
+	}
+}
+
+func TestValidateMARC(t *testing.T) {
+	tests := []struct {
+		name    string
+		marc    []byte
+		reasons []string
+	}{
+		{"valid", marcWith(testLeader, test001+testContent), nil},
+		{"short leader", marcWith("00000npcaa2200000 u", testContent), []string{MARCSchemaInvalid, MARCLeaderLength}},
+		{"missing 008", marcWith(testLeader, test245), []string{MARCMissing008}},
+		{"short 008", marcWith(testLeader, `<controlfield tag="008">000000i1900</controlfield>`+test245), []string{MARCInvalid008}},
+		{"missing 245", marcWith(testLeader, test008), []string{MARCMissing245}},
+		{"controlfield after a datafield", marcWith(testLeader, testContent+test001), []string{MARCSchemaInvalid, MARCFieldOrder}},
+		{"controlfields out of order", marcWith(testLeader, test008+test001+test245), []string{MARCFieldOrder}},
+		{"more than one failure", marcWith("00000", test245), []string{MARCSchemaInvalid, MARCLeaderLength, MARCMissing008}},
+		{"no records", []byte(`<collection xmlns="http://www.loc.gov/MARC21/slim"/>`), []string{MARCNoRecords}},
+		{"unknown element", marcWith(testLeader, testContent+"<bogus/>"), []string{MARCSchemaInvalid}},
+		{"invalid tag", marcWith(testLeader, testContent+`<datafield tag="50" ind1=" " ind2=" "><subfield code="a">note</subfield></datafield>`), []string{MARCSchemaInvalid}},
+		{"not well-formed", []byte(`<collection xmlns="http://www.loc.gov/MARC21/slim"><record>`), []string{MARCSchemaInvalid, MARCNotWellFormed}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := ValidateMARC(test.marc)
+			if len(test.reasons) == 0 {
+				if err != nil {
+					t.Fatalf("expected the marc to be valid, got %s", err.Error())
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("expected %s, the marc was valid", strings.Join(test.reasons, ","))
+			}
+			if reason := GetValidationReason(err); reason != strings.Join(test.reasons, ",") {
+				t.Errorf("expected reasons %s, got %s: %s", strings.Join(test.reasons, ","), reason, err.Error())
+			}
+		})
+	}
+}
+
+func TestValidateAgainstSchema(t *testing.T) {
+	tests := []struct {
+		name   string
+		schema string
+		doc    []byte
+		valid  bool
+	}{
+		{"ead", EAD2002Profile, eadWith(""), true},
+		{"ead3", EAD3Profile, ead3With(""), true},
+		{"ead3 as ead", EAD2002Profile, ead3With(""), false},
+		{"ead as marc", MARCSchema, eadWith(""), false},
+		{"marc", MARCSchema, marcWith(testLeader, testContent), true},
+		{"marc as ead", EAD2002Profile, marcWith(testLeader, testContent), false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			validationError := &ValidationError{}
+			if err := validateAgainstSchema(test.doc, test.schema, ValidationFailed, validationError); err != nil {
+				t.Fatal(err)
+			}
+			if valid := len(validationError.Reasons) == 0; valid != test.valid {
+				t.Errorf("expected valid to be %t, got %t: %s", test.valid, valid, validationError.Error())
+			}
+			if !test.valid && validationError.Reason() != ValidationFailed {
+				t.Errorf("expected reason %s, got %s", ValidationFailed, validationError.Reason())
+			}
+		})
+	}
+
+	validationError := &ValidationError{}
+	if err := validateAgainstSchema(eadWith(""), "missing.xsd", ValidationFailed, validationError); err == nil {
+		t.Error("expected an error for a schema that is not bundled")
+	}
+}
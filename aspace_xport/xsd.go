@@ -0,0 +1,853 @@
+package aspace_xport
+
+import (
+	"bytes"
+	"embed"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// a small validator for the subset of xml schema used by the schemas bundled in the schemas directory:
+// global and local elements, named and anonymous complex and simple types, sequence, choice, any,
+// model and attribute groups, simple content and restriction facets
+
+//go:embed schemas/*.xsd
+var schemaFiles embed.FS
+
+const maxValidationErrors = 10
+
+var (
+	schemaCache = map[string]*xmlSchema{}
+	schemaMutex sync.Mutex
+)
+
+type xmlSchema struct {
+	namespace   string
+	elements    map[string]*elementDecl
+	types       map[string]*typeDef
+	simpleTypes map[string]*simpleTypeDef
+	nodes       map[string]map[string]*xsdNode
+}
+
+type elementDecl struct {
+	name string
+	typ  *typeDef
+}
+
+type typeDef struct {
+	mixed      bool
+	simple     *simpleTypeDef
+	content    *particle
+	attributes map[string]*attributeDecl
+	anyAttr    bool
+	laxAny     bool
+	children   map[string]*elementDecl
+}
+
+type attributeDecl struct {
+	name     string
+	required bool
+	typ      *simpleTypeDef
+}
+
+type simpleTypeDef struct {
+	preserve     bool
+	enumerations []string
+	patterns     [][]*regexp.Regexp
+	length       int
+	minLength    int
+	maxLength    int
+}
+
+type particleKind int
+
+const (
+	elementParticle particleKind = iota
+	sequenceParticle
+	choiceParticle
+	anyParticle
+)
+
+type particle struct {
+	kind      particleKind
+	minOccurs int
+	maxOccurs int //a negative maxOccurs is unbounded
+	decl      *elementDecl
+	namespace string
+	children  []*particle
+}
+
+type xsdNode struct {
+	name     string
+	attrs    map[string]string
+	children []*xsdNode
+}
+
+// get a compiled schema from the bundled schema files
+func getSchema(schemaFile string) (*xmlSchema, error) {
+	schemaMutex.Lock()
+	defer schemaMutex.Unlock()
+
+	if schema, ok := schemaCache[schemaFile]; ok {
+		return schema, nil
+	}
+
+	schemaBytes, err := schemaFiles.ReadFile("schemas/" + schemaFile)
+	if err != nil {
+		return nil, err
+	}
+
+	schema, err := compileSchema(schemaBytes)
+	if err != nil {
+		return nil, fmt.Errorf("could not compile schema %s: %s", schemaFile, err.Error())
+	}
+
+	schemaCache[schemaFile] = schema
+	return schema, nil
+}
+
+func parseXSDNodes(schemaBytes []byte) (*xsdNode, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(schemaBytes))
+	var root *xsdNode
+	stack := []*xsdNode{}
+
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			if t.Name.Local == "annotation" {
+				if err := decoder.Skip(); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			node := &xsdNode{name: t.Name.Local, attrs: map[string]string{}}
+			for _, attr := range t.Attr {
+				if attr.Name.Space == "" {
+					node.attrs[attr.Name.Local] = attr.Value
+				}
+			}
+			if len(stack) == 0 {
+				root = node
+			} else {
+				parent := stack[len(stack)-1]
+				parent.children = append(parent.children, node)
+			}
+			stack = append(stack, node)
+		case xml.EndElement:
+			stack = stack[:len(stack)-1]
+		}
+	}
+
+	if root == nil || root.name != "schema" {
+		return nil, fmt.Errorf("root element is not a schema")
+	}
+	return root, nil
+}
+
+func compileSchema(schemaBytes []byte) (*xmlSchema, error) {
+	root, err := parseXSDNodes(schemaBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &xmlSchema{
+		namespace:   root.attrs["targetNamespace"],
+		elements:    map[string]*elementDecl{},
+		types:       map[string]*typeDef{},
+		simpleTypes: map[string]*simpleTypeDef{},
+		nodes:       map[string]map[string]*xsdNode{},
+	}
+
+	//index the top level definitions
+	for _, node := range root.children {
+		name := node.attrs["name"]
+		if name == "" {
+			continue
+		}
+		if _, ok := s.nodes[node.name]; !ok {
+			s.nodes[node.name] = map[string]*xsdNode{}
+		}
+		s.nodes[node.name][name] = node
+		if node.name == "element" {
+			s.elements[name] = &elementDecl{name: name}
+		}
+	}
+
+	//compile the global elements
+	for name, node := range s.nodes["element"] {
+		typ, err := s.compileElementType(node)
+		if err != nil {
+			return nil, err
+		}
+		s.elements[name].typ = typ
+	}
+
+	return s, nil
+}
+
+func localName(qname string) string {
+	if i := strings.Index(qname, ":"); i >= 0 {
+		return qname[i+1:]
+	}
+	return qname
+}
+
+func isBuiltinType(qname string) bool {
+	return strings.HasPrefix(qname, "xs:") || strings.HasPrefix(qname, "xsd:")
+}
+
+func parseOccurs(node *xsdNode) (int, int, error) {
+	minOccurs, maxOccurs := 1, 1
+	var err error
+	if v, ok := node.attrs["minOccurs"]; ok {
+		if minOccurs, err = strconv.Atoi(v); err != nil {
+			return 0, 0, err
+		}
+	}
+	if v, ok := node.attrs["maxOccurs"]; ok {
+		if v == "unbounded" {
+			maxOccurs = -1
+		} else if maxOccurs, err = strconv.Atoi(v); err != nil {
+			return 0, 0, err
+		}
+	}
+	return minOccurs, maxOccurs, nil
+}
+
+func (s *xmlSchema) compileElementType(node *xsdNode) (*typeDef, error) {
+	if typeName, ok := node.attrs["type"]; ok {
+		return s.resolveType(typeName)
+	}
+
+	for _, child := range node.children {
+		switch child.name {
+		case "complexType":
+			t := &typeDef{}
+			if err := s.fillComplexType(t, child); err != nil {
+				return nil, err
+			}
+			return t, nil
+		case "simpleType":
+			st, err := s.compileSimpleType(child)
+			if err != nil {
+				return nil, err
+			}
+			return &typeDef{simple: st}, nil
+		}
+	}
+
+	//an element without a type accepts any content
+	return &typeDef{
+		mixed:    true,
+		anyAttr:  true,
+		laxAny:   true,
+		content:  &particle{kind: anyParticle, minOccurs: 0, maxOccurs: -1, namespace: "##any"},
+		children: map[string]*elementDecl{},
+	}, nil
+}
+
+func (s *xmlSchema) resolveType(qname string) (*typeDef, error) {
+	name := localName(qname)
+	if !isBuiltinType(qname) {
+		if t, ok := s.types[name]; ok {
+			return t, nil
+		}
+		if node, ok := s.nodes["complexType"][name]; ok {
+			//register the type before filling it so recursive definitions resolve
+			t := &typeDef{}
+			s.types[name] = t
+			if err := s.fillComplexType(t, node); err != nil {
+				return nil, err
+			}
+			return t, nil
+		}
+	}
+
+	st, err := s.resolveSimpleType(qname)
+	if err != nil {
+		return nil, err
+	}
+	return &typeDef{simple: st}, nil
+}
+
+func (s *xmlSchema) fillComplexType(t *typeDef, node *xsdNode) error {
+	t.mixed = node.attrs["mixed"] == "true"
+	t.attributes = map[string]*attributeDecl{}
+	t.children = map[string]*elementDecl{}
+
+	for _, child := range node.children {
+		switch child.name {
+		case "sequence", "choice", "group":
+			p, err := s.compileParticle(child, t)
+			if err != nil {
+				return err
+			}
+			t.content = p
+		case "simpleContent":
+			for _, derivation := range child.children {
+				if derivation.name != "extension" && derivation.name != "restriction" {
+					continue
+				}
+				st, err := s.resolveSimpleType(derivation.attrs["base"])
+				if err != nil {
+					return err
+				}
+				t.simple = st
+				if err := s.addAttributes(t, derivation.children); err != nil {
+					return err
+				}
+			}
+		case "complexContent", "all":
+			return fmt.Errorf("%s is not supported", child.name)
+		}
+	}
+
+	return s.addAttributes(t, node.children)
+}
+
+func (s *xmlSchema) addAttributes(t *typeDef, nodes []*xsdNode) error {
+	for _, node := range nodes {
+		switch node.name {
+		case "attribute":
+			attr := &attributeDecl{name: node.attrs["name"], required: node.attrs["use"] == "required", typ: &simpleTypeDef{}}
+			if typeName, ok := node.attrs["type"]; ok {
+				st, err := s.resolveSimpleType(typeName)
+				if err != nil {
+					return err
+				}
+				attr.typ = st
+			}
+			for _, child := range node.children {
+				if child.name == "simpleType" {
+					st, err := s.compileSimpleType(child)
+					if err != nil {
+						return err
+					}
+					attr.typ = st
+				}
+			}
+			if attr.name != "" {
+				t.attributes[attr.name] = attr
+			}
+		case "attributeGroup":
+			group, ok := s.nodes["attributeGroup"][localName(node.attrs["ref"])]
+			if !ok {
+				return fmt.Errorf("attribute group %s is not defined", node.attrs["ref"])
+			}
+			if err := s.addAttributes(t, group.children); err != nil {
+				return err
+			}
+		case "anyAttribute":
+			t.anyAttr = true
+		}
+	}
+	return nil
+}
+
+func (s *xmlSchema) compileParticle(node *xsdNode, t *typeDef) (*particle, error) {
+	minOccurs, maxOccurs, err := parseOccurs(node)
+	if err != nil {
+		return nil, err
+	}
+	p := &particle{minOccurs: minOccurs, maxOccurs: maxOccurs}
+
+	switch node.name {
+	case "element":
+		p.kind = elementParticle
+		if ref, ok := node.attrs["ref"]; ok {
+			decl, ok := s.elements[localName(ref)]
+			if !ok {
+				return nil, fmt.Errorf("element %s is not defined", ref)
+			}
+			p.decl = decl
+		} else {
+			typ, err := s.compileElementType(node)
+			if err != nil {
+				return nil, err
+			}
+			p.decl = &elementDecl{name: node.attrs["name"], typ: typ}
+		}
+		if _, ok := t.children[p.decl.name]; !ok {
+			t.children[p.decl.name] = p.decl
+		}
+	case "any":
+		p.kind = anyParticle
+		p.namespace = node.attrs["namespace"]
+		if p.namespace == "" {
+			p.namespace = "##any"
+		}
+		if node.attrs["processContents"] == "lax" {
+			t.laxAny = true
+		}
+	case "sequence", "choice":
+		p.kind = sequenceParticle
+		if node.name == "choice" {
+			p.kind = choiceParticle
+		}
+		for _, child := range node.children {
+			c, err := s.compileParticle(child, t)
+			if err != nil {
+				return nil, err
+			}
+			p.children = append(p.children, c)
+		}
+	case "group":
+		group, ok := s.nodes["group"][localName(node.attrs["ref"])]
+		if !ok {
+			return nil, fmt.Errorf("group %s is not defined", node.attrs["ref"])
+		}
+		if len(group.children) != 1 {
+			return nil, fmt.Errorf("group %s must contain a single sequence or choice", node.attrs["ref"])
+		}
+		c, err := s.compileParticle(group.children[0], t)
+		if err != nil {
+			return nil, err
+		}
+		p.kind = sequenceParticle
+		p.children = []*particle{c}
+	default:
+		return nil, fmt.Errorf("%s is not supported in a content model", node.name)
+	}
+
+	return p, nil
+}
+
+func (s *xmlSchema) resolveSimpleType(qname string) (*simpleTypeDef, error) {
+	name := localName(qname)
+	if isBuiltinType(qname) {
+		switch name {
+		case "string":
+			return &simpleTypeDef{preserve: true}, nil
+		default:
+			return &simpleTypeDef{}, nil
+		}
+	}
+
+	if st, ok := s.simpleTypes[name]; ok {
+		return st, nil
+	}
+
+	node, ok := s.nodes["simpleType"][name]
+	if !ok {
+		return nil, fmt.Errorf("type %s is not defined", qname)
+	}
+	st, err := s.compileSimpleType(node)
+	if err != nil {
+		return nil, err
+	}
+	s.simpleTypes[name] = st
+	return st, nil
+}
+
+func (s *xmlSchema) compileSimpleType(node *xsdNode) (*simpleTypeDef, error) {
+	for _, child := range node.children {
+		if child.name != "restriction" {
+			//lists and unions are not constrained
+			continue
+		}
+
+		base := &simpleTypeDef{}
+		if baseName, ok := child.attrs["base"]; ok {
+			var err error
+			if base, err = s.resolveSimpleType(baseName); err != nil {
+				return nil, err
+			}
+		}
+
+		//copy the base type's facets and add the restriction's facets
+		st := *base
+		st.enumerations = nil
+		st.patterns = append([][]*regexp.Regexp{}, base.patterns...)
+		patterns := []*regexp.Regexp{}
+
+		for _, facet := range child.children {
+			value := facet.attrs["value"]
+			switch facet.name {
+			case "enumeration":
+				st.enumerations = append(st.enumerations, value)
+			case "pattern":
+				re, err := regexp.Compile("^(?:" + value + ")$")
+				if err != nil {
+					return nil, err
+				}
+				patterns = append(patterns, re)
+			case "whiteSpace":
+				st.preserve = value == "preserve"
+			case "length", "minLength", "maxLength":
+				n, err := strconv.Atoi(value)
+				if err != nil {
+					return nil, err
+				}
+				switch facet.name {
+				case "length":
+					st.length = n
+				case "minLength":
+					st.minLength = n
+				case "maxLength":
+					st.maxLength = n
+				}
+			}
+		}
+
+		if len(st.enumerations) == 0 {
+			st.enumerations = base.enumerations
+		}
+		if len(patterns) > 0 {
+			st.patterns = append(st.patterns, patterns)
+		}
+		return &st, nil
+	}
+
+	return &simpleTypeDef{}, nil
+}
+
+func (st *simpleTypeDef) check(value string) error {
+	if !st.preserve {
+		value = strings.Join(strings.Fields(value), " ")
+	}
+
+	if len(st.enumerations) > 0 {
+		found := false
+		for _, e := range st.enumerations {
+			if e == value {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("value %q is not one of [%s]", value, strings.Join(st.enumerations, ", "))
+		}
+	}
+
+	for _, patterns := range st.patterns {
+		found := false
+		for _, re := range patterns {
+			if re.MatchString(value) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("value %q does not match the pattern %s", value, patterns[0].String())
+		}
+	}
+
+	length := utf8.RuneCountInString(value)
+	if st.length > 0 && length != st.length {
+		return fmt.Errorf("value %q must be %d characters long", value, st.length)
+	}
+	if st.minLength > 0 && length < st.minLength {
+		return fmt.Errorf("value %q must be at least %d characters long", value, st.minLength)
+	}
+	if st.maxLength > 0 && length > st.maxLength {
+		return fmt.Errorf("value %q must be at most %d characters long", value, st.maxLength)
+	}
+
+	return nil
+}
+
+type xmlElement struct {
+	name     xml.Name
+	attrs    []xml.Attr
+	children []*xmlElement
+	text     strings.Builder
+	line     int
+}
+
+func parseXMLTree(doc []byte) (*xmlElement, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(doc))
+	var root *xmlElement
+	stack := []*xmlElement{}
+
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			line, _ := decoder.InputPos()
+			element := &xmlElement{name: t.Name, attrs: t.Attr, line: line}
+			if len(stack) == 0 {
+				if root != nil {
+					return nil, fmt.Errorf("document has more than one root element")
+				}
+				root = element
+			} else {
+				parent := stack[len(stack)-1]
+				parent.children = append(parent.children, element)
+			}
+			stack = append(stack, element)
+		case xml.EndElement:
+			stack = stack[:len(stack)-1]
+		case xml.CharData:
+			if len(stack) > 0 {
+				stack[len(stack)-1].text.Write(t)
+			}
+		}
+	}
+
+	if root == nil {
+		return nil, fmt.Errorf("document is empty")
+	}
+	return root, nil
+}
+
+type schemaValidator struct {
+	schema   *xmlSchema
+	errors   []string
+	furthest int
+}
+
+// validate a document against the schema, returning at most maxValidationErrors messages
+func (s *xmlSchema) validate(doc []byte) []string {
+	root, err := parseXMLTree(doc)
+	if err != nil {
+		return []string{fmt.Sprintf("document is not well-formed: %s", err.Error())}
+	}
+
+	decl, ok := s.elements[root.name.Local]
+	if !ok || root.name.Space != s.namespace {
+		return []string{fmt.Sprintf("root element {%s}%s is not declared in namespace %s", root.name.Space, root.name.Local, s.namespace)}
+	}
+
+	v := &schemaValidator{schema: s}
+	v.validateElement(root, decl.typ, "/"+root.name.Local)
+	return v.errors
+}
+
+func (v *schemaValidator) addError(e *xmlElement, path string, msg string) {
+	if len(v.errors) < maxValidationErrors {
+		v.errors = append(v.errors, fmt.Sprintf("%s (line %d): %s", path, e.line, msg))
+	}
+}
+
+func (v *schemaValidator) validateElement(e *xmlElement, t *typeDef, path string) {
+	if len(v.errors) >= maxValidationErrors {
+		return
+	}
+
+	//check the attributes
+	present := map[string]bool{}
+	for _, attr := range e.attrs {
+		if attr.Name.Space != "" || attr.Name.Local == "xmlns" {
+			continue
+		}
+		present[attr.Name.Local] = true
+		decl, ok := t.attributes[attr.Name.Local]
+		if !ok {
+			if !t.anyAttr {
+				v.addError(e, path, fmt.Sprintf("attribute %s is not allowed", attr.Name.Local))
+			}
+			continue
+		}
+		if err := decl.typ.check(attr.Value); err != nil {
+			v.addError(e, path, fmt.Sprintf("attribute %s: %s", attr.Name.Local, err.Error()))
+		}
+	}
+	for name, decl := range t.attributes {
+		if decl.required && !present[name] {
+			v.addError(e, path, fmt.Sprintf("required attribute %s is missing", name))
+		}
+	}
+
+	//check simple content
+	if t.simple != nil {
+		if len(e.children) > 0 {
+			v.addError(e, path, "element may not contain child elements")
+			return
+		}
+		if err := t.simple.check(e.text.String()); err != nil {
+			v.addError(e, path, err.Error())
+		}
+		return
+	}
+
+	if !t.mixed && strings.TrimSpace(e.text.String()) != "" {
+		v.addError(e, path, "text is not allowed in element-only content")
+	}
+
+	//check the content model
+	if t.content == nil {
+		if len(e.children) > 0 {
+			v.addError(e, path, fmt.Sprintf("element must be empty, found <%s>", e.children[0].name.Local))
+		}
+		return
+	}
+
+	v.furthest = 0
+	ends := v.matchRepeated(t.content, e.children, []int{0})
+	if !containsPosition(ends, len(e.children)) {
+		if v.furthest < len(e.children) {
+			v.addError(e, path, fmt.Sprintf("unexpected element <%s>", e.children[v.furthest].name.Local))
+		} else {
+			v.addError(e, path, "content is incomplete, required elements are missing")
+		}
+		return
+	}
+
+	//validate the children
+	for i, child := range e.children {
+		childPath := fmt.Sprintf("%s/%s[%d]", path, child.name.Local, i+1)
+		if child.name.Space != v.schema.namespace {
+			continue
+		}
+		if decl, ok := t.children[child.name.Local]; ok {
+			v.validateElement(child, decl.typ, childPath)
+		} else if decl, ok := v.schema.elements[child.name.Local]; ok && t.laxAny {
+			v.validateElement(child, decl.typ, childPath)
+		}
+	}
+}
+
+// match a particle between minOccurs and maxOccurs times from each of the start positions, returning the end positions
+func (v *schemaValidator) matchRepeated(p *particle, children []*xmlElement, starts []int) []int {
+	if p.minOccurs == 1 && p.maxOccurs == 1 {
+		if len(starts) == 1 {
+			return v.matchOnce(p, children, starts[0])
+		}
+		var ends []int
+		for _, pos := range starts {
+			for _, end := range v.matchOnce(p, children, pos) {
+				ends = addPosition(ends, end)
+			}
+		}
+		return ends
+	}
+
+	var ends []int
+	found := map[int]bool{}
+	seen := map[int]bool{}
+	for _, pos := range starts {
+		seen[pos] = true
+		if p.minOccurs == 0 && !found[pos] {
+			found[pos] = true
+			ends = append(ends, pos)
+		}
+	}
+
+	current := starts
+	for n := 1; p.maxOccurs < 0 || n <= p.maxOccurs; n++ {
+		var next []int
+		for _, pos := range current {
+			for _, end := range v.matchOnce(p, children, pos) {
+				next = addPosition(next, end)
+			}
+		}
+		if len(next) == 0 {
+			break
+		}
+		if n < p.minOccurs {
+			current = next
+			continue
+		}
+
+		//stop once no new positions are reachable
+		var fresh []int
+		for _, pos := range next {
+			if !found[pos] {
+				found[pos] = true
+				ends = append(ends, pos)
+			}
+			if !seen[pos] {
+				seen[pos] = true
+				fresh = append(fresh, pos)
+			}
+		}
+		if len(fresh) == 0 {
+			break
+		}
+		current = fresh
+	}
+
+	return ends
+}
+
+func (v *schemaValidator) matchOnce(p *particle, children []*xmlElement, pos int) []int {
+	switch p.kind {
+	case elementParticle, anyParticle:
+		if pos < len(children) && v.matchesName(p, children[pos].name) {
+			if pos+1 > v.furthest {
+				v.furthest = pos + 1
+			}
+			return []int{pos + 1}
+		}
+		return nil
+	case sequenceParticle:
+		current := []int{pos}
+		for _, child := range p.children {
+			current = v.matchRepeated(child, children, current)
+			if len(current) == 0 {
+				return nil
+			}
+		}
+		return current
+	case choiceParticle:
+		var ends []int
+		for _, child := range p.children {
+			for _, end := range v.matchRepeated(child, children, []int{pos}) {
+				ends = addPosition(ends, end)
+			}
+		}
+		return ends
+	}
+	return nil
+}
+
+func containsPosition(positions []int, pos int) bool {
+	for _, p := range positions {
+		if p == pos {
+			return true
+		}
+	}
+	return false
+}
+
+func addPosition(positions []int, pos int) []int {
+	if containsPosition(positions, pos) {
+		return positions
+	}
+	return append(positions, pos)
+}
+
+func (v *schemaValidator) matchesName(p *particle, name xml.Name) bool {
+	if p.kind == elementParticle {
+		return name.Local == p.decl.name && name.Space == v.schema.namespace
+	}
+
+	switch p.namespace {
+	case "##any":
+		return true
+	case "##other":
+		return name.Space != v.schema.namespace && name.Space != ""
+	case "##targetNamespace":
+		return name.Space == v.schema.namespace
+	case "##local":
+		return name.Space == ""
+	default:
+		for _, ns := range strings.Fields(p.namespace) {
+			if ns == name.Space || (ns == "##targetNamespace" && name.Space == v.schema.namespace) || (ns == "##local" && name.Space == "") {
+				return true
+			}
+		}
+		return false
+	}
+}
@@ -1,10 +1,14 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"strings"
+	"syscall"
 	"time"
 
 	export "github.com/nyudlts/aspace-export/aspace_xport"
@@ -21,49 +25,150 @@ var (
 	formattedTime        string
 	format               string
 	help                 bool
+	indent               string
+	modifiedSince        string
 	reformat             bool
-	repository           int
+	reportFormat         string
+	reportFormats        []string
+	repository           string
+	excludeRepository    string
 	resource             int
+	resourceList         string
+	identifiers          stringList
+	eadids               stringList
+	query                string
+	filterQueries        stringList
+	findingAidStatus     string
+	level                string
+	createdAfter         string
+	createdBefore        string
+	modifiedAfter        string
+	modifiedBefore       string
+	excludeList          string
+	filters              export.ResourceFilters
+	resume               string
+	retries              int
+	retryDelay           time.Duration
+	retryJitter          float64
+	retryOn              string
 	resourceInfo         []export.ResourceInfo
 	startTime            time.Time
 	timeout              int
+	pdfTimeout           time.Duration
+	includeTree          bool
+	digitalObjects       bool
+	agents               bool
+	linkedAgents         bool
+	dmd                  string
+	marcOutput           string
+	marcOutputs          []string
 	unpublishedNotes     bool
 	unpublishedResources bool
+	validate             bool
 	version              bool
 	workDir              string
 	workers              int
 )
 
+// a flag that can be set more than once
+type stringList []string
+
+func (s *stringList) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringList) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
 func init() {
 	flag.StringVar(&config, "config", "", "location of go-aspace configuration file")
 	flag.StringVar(&environment, "environment", "", "environment key of instance to export from")
-	flag.IntVar(&repository, "repository", 0, "ID of repository to be exported, leave blank to export all repositories")
+	flag.StringVar(&repository, "repository", "", "comma separated IDs or slugs of the repositories to be exported, leave blank to export all repositories")
+	flag.StringVar(&excludeRepository, "exclude-repository", "", "comma separated IDs or slugs of repositories not to be exported")
 	flag.IntVar(&resource, "resource", 0, "ID of a single resource to be exported")
+	flag.StringVar(&resourceList, "resource-list", "", "file listing the resources to be exported by repository and resource ID, URI or EADID")
+	flag.Var(&identifiers, "identifier", "identifier of a resource to be exported, can be set more than once")
+	flag.Var(&eadids, "eadid", "EADID of a resource to be exported, can be set more than once")
+	flag.StringVar(&query, "query", "", "ArchivesSpace search query of the resources to be exported")
+	flag.Var(&filterQueries, "filter-query", "filter query of the --query search, e.g. subjects:\"Labor unions\", can be set more than once")
+	flag.StringVar(&findingAidStatus, "finding-aid-status", "", "comma separated finding aid statuses of the resources to be exported")
+	flag.StringVar(&level, "level", "", "comma separated levels of the resources to be exported")
+	flag.StringVar(&createdAfter, "created-after", "", "only export resources created at or after a timestamp")
+	flag.StringVar(&createdBefore, "created-before", "", "only export resources created before a timestamp")
+	flag.StringVar(&modifiedAfter, "modified-after", "", "only export resources last modified at or after a timestamp")
+	flag.StringVar(&modifiedBefore, "modified-before", "", "only export resources last modified before a timestamp")
+	flag.StringVar(&excludeList, "exclude", "", "file listing the resources not to be exported by URI, identifier or EADID")
 	flag.IntVar(&workers, "workers", 8, "number of concurrent workers")
 	flag.StringVar(&exportLoc, "export-location", "", "location to export finding aids")
 	flag.BoolVar(&help, "help", false, "display the help message")
 	flag.BoolVar(&version, "version", false, "display the version of the tool and go-aspace library")
-	flag.BoolVar(&reformat, "reformat", false, "tab reformat the output file")
-	flag.StringVar(&format, "format", "", "format of export: ead or marc")
+	flag.BoolVar(&reformat, "reformat", false, "reformat the exported ead and marc xml files")
+	flag.StringVar(&indent, "indent", "tab", "indentation used by --reformat: `tab` or a number of spaces")
+	flag.StringVar(&format, "format", "", "comma separated formats of export: ead, ead3, marc, marc-json, mods, dc, pdf, json or labels")
+	flag.BoolVar(&digitalObjects, "digital-objects", false, "export the digital objects of each repository as mets")
+	flag.StringVar(&dmd, "dmd", "mods", "descriptive metadata of exported digital objects: mods or dc")
+	flag.StringVar(&marcOutput, "marc-output", "xml", "comma separated marc outputs: xml, mrc, collection")
+	flag.BoolVar(&agents, "agents", false, "export agents as eac-cpf")
+	flag.BoolVar(&linkedAgents, "linked-agents", false, "only export agents linked to published resources in the exported repositories")
+	flag.BoolVar(&includeTree, "include-tree", false, "include the archival object tree, archival objects and top containers in json exports")
+	flag.DurationVar(&pdfTimeout, "pdf-timeout", 5*time.Minute, "time to wait for archivesspace to generate a pdf")
 	flag.BoolVar(&unpublishedNotes, "include-unpublished-notes", false, "include unpublished notes")
 	flag.BoolVar(&unpublishedResources, "include-unpublished-resources", false, "include unpublished resources")
+	flag.BoolVar(&validate, "validate", false, "check exported ead and ead3 against the ead2002 and ead3 structural profiles and validate marc against the marc21 slim schema")
+	flag.StringVar(&modifiedSince, "modified-since", "", "only export resources modified since a timestamp or `last-run`")
+	flag.StringVar(&resume, "resume", "", "resume an interrupted export from the journal in a work directory")
+	flag.IntVar(&retries, "retries", 3, "maximum number of attempts for each request to ArchivesSpace")
+	flag.DurationVar(&retryDelay, "retry-delay", time.Second, "delay before the first retry, doubled for each further retry")
+	flag.Float64Var(&retryJitter, "retry-jitter", 0.2, "fraction the retry delay is randomly varied by")
+	flag.StringVar(&retryOn, "retry-on", "server,rate-limit,timeout,network", "comma separated classes of error to retry: server, rate-limit, timeout, network")
+	flag.StringVar(&reportFormat, "report-format", "", "comma separated structured report formats to write in addition to the text report: json, csv")
 	flag.BoolVar(&debug, "debug", false, "")
 }
 
 func printHelp() {
 	fmt.Println("usage: aspace-export [options]")
 	fmt.Println("options:")
+	fmt.Println("  --agents           export people, families and corporate entities as eac-cpf, --format is optional	default `false`")
 	fmt.Println("  --config           path/to/the go-aspace configuration file					mandatory")
+	fmt.Println("  --eadid            EADID of a resource to be exported, can be set more than once		default ``")
 	fmt.Println("  --environment      environment key in config file of the instance to run export against   	mandatory")
-	fmt.Println("  --format           the export format either `ead` or `marc`					mandatory")
+	fmt.Println("  --filter-query     filter of the --query search, e.g. `subjects:\"Labor unions\"`, can be set more than once	default ``")
+	fmt.Println("  --finding-aid-status	comma separated finding aid statuses of resources to export		default ``")
+	fmt.Println("  --format           comma separated export formats `ead`, `ead3`, `marc`, `marc-json`, `mods`, `dc`, `pdf`, `json` or `labels`	mandatory")
+	fmt.Println("  --digital-objects  export the digital objects of each repository as mets, --format is optional	default `false`")
+	fmt.Println("  --created-after    only export resources created at or after a timestamp			default ``")
+	fmt.Println("  --created-before   only export resources created before a timestamp				default ``")
+	fmt.Println("  --dmd              descriptive metadata of exported digital objects, `mods` or `dc`		default `mods`")
+	fmt.Println("  --exclude          path/to/a file of resource URIs, identifiers or EADIDs not to export	default ``")
+	fmt.Println("  --exclude-repository	comma separated IDs or slugs of repositories not to be exported		default ``")
 	fmt.Println("  --export-location  path/to/the location to export finding aids                            	default `.`")
+	fmt.Println("  --identifier       identifier of a resource to be exported, can be set more than once	default ``")
+	fmt.Println("  --include-tree     include the archival object tree and top containers in json exports	default `false`")
 	fmt.Println("  --include-unpublished-notes		include unpublished notes in exports			default `false`")
 	fmt.Println("  --include-unpublished-resources	include unpublished resources in exports		default `false`")
-	fmt.Println("  --reformat         tab reformat ead xml files							default `false`")
-	fmt.Println("  --repository       ID of the repository to be exported, `0` will export all repositories	default `0` ")
+	fmt.Println("  --level            comma separated levels of resources to export, e.g. `collection`	default ``")
+	fmt.Println("  --linked-agents    only export agents linked to published resources in the repositories	default `false`")
+	fmt.Println("  --marc-output      comma separated marc outputs `xml`, `mrc` (per repository) or `collection` (per repository)	default `xml`")
+	fmt.Println("  --modified-after   only export resources last modified at or after a timestamp		default ``")
+	fmt.Println("  --modified-before  only export resources last modified before a timestamp			default ``")
+	fmt.Println("  --modified-since   only export resources modified since a timestamp or `last-run`		default ``")
+	fmt.Println("  --pdf-timeout      time to wait for archivesspace to generate a pdf				default `5m`")
+	fmt.Println("  --query            ArchivesSpace search query of the resources to export, in the selected repositories	default ``")
+	fmt.Println("  --reformat         reformat exported ead and marc xml files					default `false`")
+	fmt.Println("  --indent           indentation used by --reformat, `tab` or a number of spaces		default `tab`")
+	fmt.Println("  --report-format    structured reports to write in addition to the text report: json, csv	default ``")
+	fmt.Println("  --repository       comma separated IDs or slugs of the repositories to be exported, `0` will export all	default `0` ")
 	fmt.Println("  --resource         ID of the resource to be exported, `0` will export all resources		default `0` ")
+	fmt.Println("  --resource-list    path/to/a csv, tsv or text file of repository and resource IDs, URIs or EADIDs	default ``")
+	fmt.Println("  --resume           path/to/a work directory of an interrupted export to resume			default ``")
+	fmt.Println("  --retries          maximum number of attempts for each request to ArchivesSpace		default `3`")
+	fmt.Println("  --retry-delay      delay before the first retry, doubled for each further retry		default `1s`")
+	fmt.Println("  --retry-jitter     fraction the retry delay is randomly varied by				default `0.2`")
+	fmt.Println("  --retry-on         error classes to retry: server, rate-limit, timeout, network		default `all`")
 	fmt.Println("  --workers          number of concurrent export workers to create				default `8`")
-	fmt.Println("  --validate         validate exported finding aids against ead2002 schema			default `false`")
+	fmt.Println("  --validate         check exported ead, ead3 and marc against the bundled schemas and profiles and check pdfs	default `false`")
 	fmt.Println("  --debug	     print debug messages							default `false`")
 	fmt.Println("  --version          print the version and version of client version")
 }
@@ -104,7 +209,7 @@ func main() {
 	export.LogOnly(fmt.Sprintf("aspace-export %s", appVersion), export.INFO)
 
 	//check critical flags
-	err = export.CheckFlags(config, environment, format, resource, repository)
+	err = export.CheckFlags(config, environment, format, resource, resourceList, identifiers, eadids, query, filterQueries, repository, modifiedSince, exportLoc, resume, digitalObjects, dmd, agents || linkedAgents)
 	if err != nil {
 		export.PrintAndLog(err.Error(), export.FATAL)
 		err = export.CloseLogger()
@@ -115,10 +220,87 @@ func main() {
 		os.Exit(2)
 	}
 
+	//create the retry policy for requests to ArchivesSpace
+	retryable, err := export.ParseRetryable(retryOn)
+	if err == nil && (retries < 1 || retryDelay < 0 || retryJitter < 0 || retryJitter > 1) {
+		err = fmt.Errorf("--retries must be at least 1, --retry-delay can not be negative and --retry-jitter must be between 0 and 1")
+	}
+	if err != nil {
+		export.PrintAndLog(err.Error(), export.FATAL)
+		err = export.CloseLogger()
+		if err != nil {
+			export.PrintAndLog(err.Error(), export.ERROR)
+		}
+		printHelp()
+		os.Exit(2)
+	}
+	export.SetRetryPolicy(export.RetryPolicy{MaxAttempts: retries, BaseDelay: retryDelay, Jitter: retryJitter, Retryable: retryable})
+
+	//check the indentation used to reformat xml
+	indentString, err := export.GetIndent(indent)
+	if err != nil {
+		export.PrintAndLog(err.Error(), export.FATAL)
+		err = export.CloseLogger()
+		if err != nil {
+			export.PrintAndLog(err.Error(), export.ERROR)
+		}
+		printHelp()
+		os.Exit(2)
+	}
+
+	//check the structured report formats
+	reportFormats, err = export.ParseReportFormats(reportFormat)
+	if err != nil {
+		export.PrintAndLog(err.Error(), export.FATAL)
+		err = export.CloseLogger()
+		if err != nil {
+			export.PrintAndLog(err.Error(), export.ERROR)
+		}
+		printHelp()
+		os.Exit(2)
+	}
+
+	//check the marc outputs
+	marcOutputs, err = export.ParseMARCOutputs(marcOutput)
+	if err != nil {
+		export.PrintAndLog(err.Error(), export.FATAL)
+		err = export.CloseLogger()
+		if err != nil {
+			export.PrintAndLog(err.Error(), export.ERROR)
+		}
+		printHelp()
+		os.Exit(2)
+	}
+
+	//check the resource filters
+	filters, err = getResourceFilters()
+	if err != nil {
+		export.PrintAndLog(err.Error(), export.FATAL)
+		err = export.CloseLogger()
+		if err != nil {
+			export.PrintAndLog(err.Error(), export.ERROR)
+		}
+		printHelp()
+		os.Exit(2)
+	}
+
+	//check the time to wait for a pdf
+	if pdfTimeout <= 0 {
+		export.PrintAndLog("--pdf-timeout must be greater than 0", export.FATAL)
+		err = export.CloseLogger()
+		if err != nil {
+			export.PrintAndLog(err.Error(), export.ERROR)
+		}
+		printHelp()
+		os.Exit(2)
+	}
+
 	export.PrintAndLog("all mandatory options set", export.INFO)
 
-	//get the absolute path of the export location
-	if exportLoc == "" {
+	//get the absolute path of the export location, a resumed export uses the work directory of the original run
+	if resume != "" {
+		workDir = resume
+	} else if exportLoc == "" {
 		workDir = fmt.Sprintf("aspace-exports-%s", formattedTime)
 		if err = export.CreateWorkDirectory(workDir); err != nil {
 			export.PrintAndLog(err.Error(), export.FATAL)
@@ -164,8 +346,13 @@ func main() {
 		export.PrintAndLog(fmt.Sprintf("go-aspace client created, using go-aspace %s", aspace.LibraryVersion), export.INFO)
 	}
 
+	//resume an interrupted export
+	if resume != "" {
+		resumeExport()
+	}
+
 	//get a map of repositories to be exported
-	repositoryMap, err := export.GetRepositoryMap(repository, environment)
+	repositoryMap, err := export.GetRepositoryMap(repository, excludeRepository, environment)
 	if err != nil {
 		export.PrintAndLog(err.Error(), export.FATAL)
 		err = export.CloseLogger()
@@ -176,54 +363,251 @@ func main() {
 	}
 	export.PrintAndLog(fmt.Sprintf("%d repositories returned from ArchivesSpace", len(repositoryMap)), export.INFO)
 
-	//get a slice of resourceInfo
-	resourceInfo, err = export.GetResourceIDs(repositoryMap, resource)
-	if err != nil {
-		export.PrintAndLog(err.Error(), export.FATAL)
-		err = export.CloseLogger()
+	//get the times to export modified resources and digital objects from
+	modifiedSinceMap := map[int]time.Time{}
+	if format != "" {
+		modifiedSinceMap, err = export.GetModifiedSince(modifiedSince, workDir, environment, repositoryMap, "")
 		if err != nil {
-			export.PrintAndLog(err.Error(), export.ERROR)
+			export.PrintAndLog(err.Error(), export.FATAL)
+			err = export.CloseLogger()
+			if err != nil {
+				export.PrintAndLog(err.Error(), export.ERROR)
+			}
+			os.Exit(11)
+		}
+	}
+	digitalObjectModifiedSinceMap := map[int]time.Time{}
+	if digitalObjects == true {
+		digitalObjectModifiedSinceMap, err = export.GetModifiedSince(modifiedSince, workDir, environment, repositoryMap, export.DigitalObjectType)
+		if err != nil {
+			export.PrintAndLog(err.Error(), export.FATAL)
+			err = export.CloseLogger()
+			if err != nil {
+				export.PrintAndLog(err.Error(), export.ERROR)
+			}
+			os.Exit(11)
+		}
+	}
+
+	//get a slice of resourceInfo, only digital objects are exported if a format is not set
+	if selectedResources() {
+		resourceInfo, err = export.GetSelectedResourceIDs(repositoryMap, resourceList, identifiers, eadids, query, filterQueries)
+		if err != nil {
+			export.PrintAndLog(err.Error(), export.FATAL)
+			err = export.CloseLogger()
+			if err != nil {
+				export.PrintAndLog(err.Error(), export.ERROR)
+			}
+			os.Exit(6)
+		}
+		export.PrintAndLog(fmt.Sprintf("%d selected resources found in ArchivesSpace", len(resourceInfo)), export.INFO)
+	} else if format != "" {
+		resourceInfo, err = export.GetResourceIDs(repositoryMap, resource, modifiedSinceMap)
+		if err != nil {
+			export.PrintAndLog(err.Error(), export.FATAL)
+			err = export.CloseLogger()
+			if err != nil {
+				export.PrintAndLog(err.Error(), export.ERROR)
+			}
+			os.Exit(6)
+		}
+		export.PrintAndLog(fmt.Sprintf("%d resources returned from ArchivesSpace", len(resourceInfo)), export.INFO)
+	}
+
+	//get the digital objects, they are queued after the resources
+	if digitalObjects == true {
+		digitalObjectInfo, err := export.GetDigitalObjectIDs(repositoryMap, digitalObjectModifiedSinceMap)
+		if err != nil {
+			export.PrintAndLog(err.Error(), export.FATAL)
+			err = export.CloseLogger()
+			if err != nil {
+				export.PrintAndLog(err.Error(), export.ERROR)
+			}
+			os.Exit(6)
+		}
+		export.PrintAndLog(fmt.Sprintf("%d digital objects returned from ArchivesSpace", len(digitalObjectInfo)), export.INFO)
+		resourceInfo = append(resourceInfo, digitalObjectInfo...)
+	}
+
+	//get the agents, they are queued after the resources and digital objects
+	if agents == true || linkedAgents == true {
+		agentInfo, err := export.GetAgentIDs(repositoryMap, linkedAgents)
+		if err != nil {
+			export.PrintAndLog(err.Error(), export.FATAL)
+			err = export.CloseLogger()
+			if err != nil {
+				export.PrintAndLog(err.Error(), export.ERROR)
+			}
+			os.Exit(6)
+		}
+		export.PrintAndLog(fmt.Sprintf("%d agents returned from ArchivesSpace", len(agentInfo)), export.INFO)
+		resourceInfo = append(resourceInfo, agentInfo...)
+	}
+
+	//Validate the export formats
+	xportFormats := []export.ExportFormat{}
+	if format != "" {
+		xportFormats, err = export.GetExportFormats(format)
+		if err != nil {
+			export.PrintAndLog(err.Error(), export.FATAL)
+			err = export.CloseLogger()
+			if err != nil {
+				export.PrintAndLog(err.Error(), export.ERROR)
+			}
+			os.Exit(9)
 		}
-		os.Exit(6)
 	}
-	export.PrintAndLog(fmt.Sprintf("%d resources returned from ArchivesSpace", len(resourceInfo)), export.INFO)
 
 	//Create the repository export and failure directories
-	err = export.CreateExportDirectories(workDir, repositoryMap, unpublishedResources)
-	if err != nil {
-		export.PrintAndLog(err.Error(), export.FATAL)
-		err = export.CloseLogger()
+	if len(xportFormats) > 0 {
+		err = export.CreateExportDirectories(workDir, repositoryMap, xportFormats, unpublishedResources, validate)
 		if err != nil {
-			export.PrintAndLog(err.Error(), export.ERROR)
+			export.PrintAndLog(err.Error(), export.FATAL)
+			err = export.CloseLogger()
+			if err != nil {
+				export.PrintAndLog(err.Error(), export.ERROR)
+			}
+			os.Exit(8)
 		}
-		os.Exit(8)
 	}
 
-	//Validate the export format
-	xportFormat, err := export.GetExportFormat(format)
-	if err != nil {
-		export.PrintAndLog(err.Error(), export.FATAL)
-		err = export.CloseLogger()
+	//Create the digital object directories
+	if digitalObjects == true {
+		err = export.CreateDigitalObjectDirectories(workDir, repositoryMap)
 		if err != nil {
-			export.PrintAndLog(err.Error(), export.ERROR)
+			export.PrintAndLog(err.Error(), export.FATAL)
+			err = export.CloseLogger()
+			if err != nil {
+				export.PrintAndLog(err.Error(), export.ERROR)
+			}
+			os.Exit(8)
+		}
+	}
+
+	//Create the agent directories
+	if agents == true || linkedAgents == true {
+		err = export.CreateAgentDirectories(workDir)
+		if err != nil {
+			export.PrintAndLog(err.Error(), export.FATAL)
+			err = export.CloseLogger()
+			if err != nil {
+				export.PrintAndLog(err.Error(), export.ERROR)
+			}
+			os.Exit(8)
 		}
-		os.Exit(9)
 	}
 
 	//create ExportOptions struct
 	xportOptions := export.ExportOptions{
 		WorkDir:              workDir,
-		Format:               xportFormat,
+		Formats:              xportFormats,
 		UnpublishedNotes:     unpublishedNotes,
 		UnpublishedResources: unpublishedResources,
 		Workers:              workers,
 		Reformat:             reformat,
+		Indent:               indentString,
+		Validate:             validate,
+		PDFTimeout:           pdfTimeout,
+		IncludeTree:          includeTree,
+		DigitalObjectDMD:     dmd,
+		MARCOutputs:          marcOutputs,
+		Filters:              filters,
 		Timestamp:            formattedTime,
+		ReportFormats:        reportFormats,
+	}
+
+	//export resources, digital objects and agents
+	export.PrintAndLog(fmt.Sprintf("processing %d records", len(resourceInfo)), export.INFO)
+	if interrupted := exportResources(xportOptions); interrupted {
+		finish(13)
 	}
 
-	//export resources
-	export.PrintAndLog(fmt.Sprintf("processing %d resources", len(resourceInfo)), export.INFO)
-	err = export.ExportResources(xportOptions, startTime, formattedTime, &resourceInfo)
+	//record the time of this run for incremental exports of resources, if every resource was exported, and of digital objects
+	stateRecordTypes := []string{}
+	if resource == 0 && !selectedResources() && format != "" && !filtered() {
+		stateRecordTypes = append(stateRecordTypes, "")
+	}
+	if digitalObjects == true {
+		stateRecordTypes = append(stateRecordTypes, export.DigitalObjectType)
+	}
+	if len(stateRecordTypes) > 0 {
+		if err := export.UpdateExportState(workDir, environment, repositoryMap, stateRecordTypes, startTime); err != nil {
+			export.PrintAndLog(fmt.Sprintf("failed to update the export state file: %s", err.Error()), export.WARNING)
+		}
+	}
+
+	finish(0)
+}
+
+// check if resources are selected by a resource list, identifier, EADID or search query
+func selectedResources() bool {
+	return resourceList != "" || len(identifiers) > 0 || len(eadids) > 0 || query != ""
+}
+
+// check if any resource filter is set, a filtered run does not export every resource
+func filtered() bool {
+	return findingAidStatus != "" || level != "" || createdAfter != "" || createdBefore != "" || modifiedAfter != "" ||
+		modifiedBefore != "" || excludeList != ""
+}
+
+// get the filters resources must pass to be exported
+func getResourceFilters() (export.ResourceFilters, error) {
+	resourceFilters := export.ResourceFilters{
+		FindingAidStatuses: export.ParseFilterValues(findingAidStatus),
+		Levels:             export.ParseFilterValues(level),
+	}
+
+	times := []struct {
+		name  string
+		value string
+		t     *time.Time
+	}{
+		{"--created-after", createdAfter, &resourceFilters.CreatedAfter},
+		{"--created-before", createdBefore, &resourceFilters.CreatedBefore},
+		{"--modified-after", modifiedAfter, &resourceFilters.ModifiedAfter},
+		{"--modified-before", modifiedBefore, &resourceFilters.ModifiedBefore},
+	}
+	for _, filterTime := range times {
+		t, err := export.ParseFilterTime(filterTime.name, filterTime.value)
+		if err != nil {
+			return resourceFilters, err
+		}
+		*filterTime.t = t
+	}
+
+	if excludeList != "" {
+		exclude, err := export.ReadExclusionList(excludeList)
+		if err != nil {
+			return resourceFilters, err
+		}
+		resourceFilters.Exclude = exclude
+	}
+	return resourceFilters, nil
+}
+
+// run the export, stopping gracefully on SIGINT or SIGTERM, returns true if the export was interrupted
+func exportResources(xportOptions export.ExportOptions) bool {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	//cancel the export on the first signal and restore the default signal handling so a second signal exits immediately
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case sig := <-signals:
+			signal.Stop(signals)
+			export.PrintAndLog(fmt.Sprintf("received %s, finishing the exports in progress, send again to exit immediately", sig), export.WARNING)
+			cancel()
+		case <-done:
+		}
+	}()
+
+	err := export.ExportResources(ctx, xportOptions, startTime, formattedTime, &resourceInfo)
 	if err != nil {
 		export.PrintAndLog(err.Error(), export.FATAL)
 		err = export.CloseLogger()
@@ -233,6 +617,71 @@ func main() {
 		os.Exit(10)
 	}
 
+	return ctx.Err() != nil
+}
+
+// resume the export recorded in the journal of the work directory, the options of the original run are used
+func resumeExport() {
+	xportOptions, remaining, err := export.ResumeExport(workDir)
+	if err != nil {
+		export.PrintAndLog(fmt.Sprintf("could not resume export from %s: %s", workDir, err.Error()), export.FATAL)
+		err = export.CloseLogger()
+		if err != nil {
+			export.PrintAndLog(err.Error(), export.ERROR)
+		}
+		os.Exit(12)
+	}
+	resourceInfo = remaining
+	export.PrintAndLog(fmt.Sprintf("resuming export in %s, %d records remaining", workDir, len(resourceInfo)), export.INFO)
+
+	//recreate any missing export directories for the repositories still to be exported
+	repositoryMap := map[string]int{}
+	digitalObjectRepositoryMap := map[string]int{}
+	remainingAgents := false
+	for _, rInfo := range resourceInfo {
+		switch rInfo.Type {
+		case "":
+			repositoryMap[rInfo.RepoSlug] = rInfo.RepoID
+		case export.DigitalObjectType:
+			digitalObjectRepositoryMap[rInfo.RepoSlug] = rInfo.RepoID
+		default:
+			remainingAgents = true
+		}
+	}
+	err = export.CreateExportDirectories(workDir, repositoryMap, xportOptions.Formats, xportOptions.UnpublishedResources, xportOptions.Validate)
+	if err == nil {
+		err = export.CreateDigitalObjectDirectories(workDir, digitalObjectRepositoryMap)
+	}
+	if err == nil && remainingAgents {
+		err = export.CreateAgentDirectories(workDir)
+	}
+	if err != nil {
+		export.PrintAndLog(err.Error(), export.FATAL)
+		err = export.CloseLogger()
+		if err != nil {
+			export.PrintAndLog(err.Error(), export.ERROR)
+		}
+		os.Exit(8)
+	}
+
+	//the work directory may have moved and the worker count may be changed on resume
+	xportOptions.WorkDir = workDir
+	xportOptions.Workers = workers
+	if len(reportFormats) > 0 {
+		xportOptions.ReportFormats = reportFormats
+	}
+	xportOptions.Timestamp = formattedTime
+
+	export.PrintAndLog(fmt.Sprintf("processing %d records", len(resourceInfo)), export.INFO)
+	if interrupted := exportResources(xportOptions); interrupted {
+		finish(13)
+	}
+
+	finish(0)
+}
+
+// close the logger, clean up the work directory, print the report and exit
+func finish(exitCode int) {
 	export.PrintAndLog("closing logger", export.INFO)
 	if err := export.CloseLogger(); err != nil {
 		export.LogOnly(fmt.Sprintf("failed to close logger: %s", err.Error()), export.WARNING)
@@ -248,12 +697,16 @@ func main() {
 		export.PrintOnly("moved log to work directory", export.INFO)
 	}
 
-	export.PrintOnly("aspace export complete", export.INFO)
+	if exitCode == 0 {
+		export.PrintOnly("aspace export complete", export.INFO)
+	} else {
+		export.PrintOnly(fmt.Sprintf("aspace export interrupted, resume with --resume %s", workDir), export.WARNING)
+	}
 
 	//print the report
 	if err := export.PrintReport(); err != nil {
 		export.PrintOnly(fmt.Sprintf("failed to print report file: %s", err.Error()), export.WARNING)
 	}
 
-	os.Exit(0)
+	os.Exit(exitCode)
 }